@@ -2,70 +2,279 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"math/rand"
 	"os"
 	"sort"
 	"strconv"
+	"strings"
 )
 
+/* Errors should be printed to STDERR not STDOUT */
+func die(err error) {
+	fmt.Fprintf(os.Stderr, "%s\n", err)
+	closeTrace()
+	os.Exit(1)
+}
+
+var logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+var tracer *slog.Logger
+
+var closeTrace = func() {}
+
+func setupLogging(verbose bool, veryVerbose bool, traceFile string) (func(), error) {
+	level := slog.LevelWarn
+	if verbose {
+		level = slog.LevelInfo
+	}
+	if veryVerbose {
+		level = slog.LevelDebug
+	}
+	logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	if traceFile == "" {
+		return func() {}, nil
+	}
+	file, err := os.Create(traceFile)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewWriter(file)
+	tracer = slog.New(slog.NewJSONHandler(buffered, nil))
+	return func() {
+		if err := buffered.Flush(); err != nil {
+			logger.Warn("Failed to write the trace", "file", traceFile, "err", err)
+		}
+		if err := file.Close(); err != nil {
+			logger.Warn("Failed to close the trace", "file", traceFile, "err", err)
+		}
+	}, nil
+}
+
+/*
+ * An expense report with exactly one pair and one triple summing to 2020,
+ * counting an entry twice too so a search that reuses one still finds the
+ * planted answer. Every other entry is only kept if it can't make 2020 with
+ * what's already there.
+ */
+func generateExpenses(random *rand.Rand, size int) ([]string, int, int) {
+	var pair, triple []int
+	for {
+		pair = []int{1 + random.Intn(2019)}
+		pair = append(pair, 2020-pair[0])
+		triple = []int{1 + random.Intn(1000)}
+		triple = append(triple, 1+random.Intn(2018-triple[0]))
+		triple = append(triple, 2020-triple[0]-triple[1])
+		if isOnlyPlanted(append(append([]int{}, pair...), triple...)) {
+			break
+		}
+	}
+
+	seen := make(map[int]bool)
+	var expenses []int
+	for _, expense := range append(pair, triple...) {
+		expenses = append(expenses, expense)
+		seen[expense] = true
+	}
+	makes2020 := func(expense int) bool {
+		if seen[expense] || seen[2020-expense] || seen[2020-expense*2] || expense*2 == 2020 || expense*3 == 2020 {
+			return true
+		}
+		for other := range seen {
+			if rest := 2020 - expense - other; seen[rest] || rest == expense {
+				return true
+			}
+		}
+		return false
+	}
+	/* Give up rather than spin if size is more than 2020 leaves room for */
+	for attempts := 0; len(expenses) < size && attempts < size*100; attempts++ {
+		if expense := 1 + random.Intn(2019); !makes2020(expense) {
+			expenses = append(expenses, expense)
+			seen[expense] = true
+		}
+	}
+
+	random.Shuffle(len(expenses), func(i, j int) {
+		expenses[i], expenses[j] = expenses[j], expenses[i]
+	})
+	var lines []string
+	for _, expense := range expenses {
+		lines = append(lines, strconv.Itoa(expense))
+	}
+	return lines, pair[0] * pair[1], triple[0] * triple[1] * triple[2]
+}
+
+/* The planted entries are all different and make one pair and one triple */
+func isOnlyPlanted(planted []int) bool {
+	var pairs, triples int
+	for i := range planted {
+		for j := 0; j < i; j++ {
+			if planted[i] == planted[j] {
+				return false
+			}
+		}
+		for j := i; j < len(planted); j++ {
+			if planted[i]+planted[j] == 2020 {
+				pairs++
+			}
+			for k := j; k < len(planted); k++ {
+				if planted[i]+planted[j]+planted[k] == 2020 {
+					triples++
+				}
+			}
+		}
+	}
+	return pairs == 1 && triples == 1
+}
+
+func writeGenerated(prefix string, lines []string, part1 int, part2 int) error {
+	if err := ioutil.WriteFile(prefix, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(prefix+".answers", []byte(fmt.Sprintf("%d\n%d\n", part1, part2)), 0644)
+}
+
+/* Expenses must be sorted, so each search can stop once the sum passes 2020 */
+func findPair(expenses []int) (int, bool) {
+	for i := 0; i < len(expenses); i++ {
+		for j := i + 1; j < len(expenses); j++ {
+			if tracer != nil {
+				tracer.Info("pair", "a", expenses[i], "b", expenses[j], "sum", expenses[i]+expenses[j])
+			}
+			if 2020 == expenses[i]+expenses[j] {
+				return expenses[i] * expenses[j], true
+			}
+			if 2020 < expenses[i]+expenses[j] {
+				break
+			}
+		}
+	}
+	return 0, false
+}
+
+func findTriple(expenses []int) (int, bool) {
+	for i := 0; i < len(expenses); i++ {
+		for j := i + 1; j < len(expenses); j++ {
+			if 2020 < expenses[i]+expenses[j] {
+				break
+			}
+			for k := j + 1; k < len(expenses); k++ {
+				if tracer != nil {
+					tracer.Info("triple", "a", expenses[i], "b", expenses[j], "c", expenses[k], "sum", expenses[i]+expenses[j]+expenses[k])
+				}
+				if 2020 == expenses[i]+expenses[j]+expenses[k] {
+					return expenses[i] * expenses[j] * expenses[k], true
+				}
+				if 2020 < expenses[i]+expenses[j]+expenses[k] {
+					break
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+/* Every pair and triple in input order, with no shortcuts, to check the searches above against */
+func referencePair(expenses []int) (int, bool) {
+	for i := range expenses {
+		for j := range expenses {
+			if i < j && 2020 == expenses[i]+expenses[j] {
+				return expenses[i] * expenses[j], true
+			}
+		}
+	}
+	return 0, false
+}
+
+func referenceTriple(expenses []int) (int, bool) {
+	for i := range expenses {
+		for j := range expenses {
+			for k := range expenses {
+				if i < j && j < k && 2020 == expenses[i]+expenses[j]+expenses[k] {
+					return expenses[i] * expenses[j] * expenses[k], true
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
 func main() {
 	var fileName string
 	var part2 bool
+	var reference bool
 	var expenses []int
-	var expensesSubset []int
-	var expensesSubsetSubset []int // what a crappy hack
-	var loop int
+	var generate string
+	var seed int64
+	var size int
+	var verbose bool
+	var veryVerbose bool
+	var traceFile string
 
 	flag.StringVar(&fileName, "f", "input", "Input file")
+	flag.StringVar(&generate, "generate", "", "Write a random input to this file and its answers to the file plus .answers")
+	flag.Int64Var(&seed, "seed", 1, "Seed for -generate")
+	flag.IntVar(&size, "size", 200, "Number of expenses for -generate")
+	flag.BoolVar(&reference, "reference", false, "Use the brute force search, for cross checking")
+	flag.BoolVar(&verbose, "v", false, "Log progress to STDERR")
+	flag.BoolVar(&veryVerbose, "vv", false, "Log progress and details to STDERR")
+	flag.StringVar(&traceFile, "trace", "", "Write each sum tried to this file as JSON lines")
 	flag.BoolVar(&part2, "2", false, "Compute part 2 of the exercise")
 	flag.Parse()
 
-	file, err := os.Open(fileName)
+	flush, err := setupLogging(verbose, veryVerbose, traceFile)
 	if err != nil {
-		fmt.Println("File reading error", err)
+		die(err)
+	}
+	closeTrace = flush
+	defer closeTrace()
+
+	if generate != "" {
+		lines, part1Answer, part2Answer := generateExpenses(rand.New(rand.NewSource(seed)), size)
+		if err := writeGenerated(generate, lines, part1Answer, part2Answer); err != nil {
+			die(fmt.Errorf("File writing error %s", err))
+		}
 		return
 	}
 
+	file, err := os.Open(fileName)
+	if err != nil {
+		die(fmt.Errorf("File reading error %s", err))
+	}
+
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		data, err := strconv.Atoi(scanner.Text())
 		if err != nil {
-			fmt.Println("Unable to convert text to int", err)
+			logger.Warn("Unable to convert text to int", "err", err)
 		}
 		expenses = append(expenses, data)
 	}
 
-	sort.Ints(expenses)
-
-	if part2 {
-		for i := 0; i < len(expenses); i++ {
-			loop++
-			expensesSubset = expenses[loop:]
-			expensesSubsetSubset = expenses[loop+1:]
-			for j := 0; j < len(expensesSubset); j++ {
-				for k := 0; k < len(expensesSubsetSubset); k++ {
-					if 2020 == expenses[i]+expensesSubset[j]+expensesSubsetSubset[k] {
-						fmt.Println(expenses[i] * expensesSubset[j] * expensesSubsetSubset[k])
-						return
-					}
-					if 2020 < expenses[i]+expensesSubset[j]+expensesSubsetSubset[k] {
-						break
-					}
-				}
-			}
-		}
-	} else {
-		for i := 0; i < len(expenses); i++ {
-			loop++
-			expensesSubset = expenses[loop:]
-			for j := 0; j < len(expensesSubset); j++ {
-				if 2020 == expenses[i]+expensesSubset[j] {
-					fmt.Println(expenses[i] * expensesSubset[j])
-					return
-				}
-			}
-		}
-	}
+	logger.Info("Read expenses", "file", fileName, "expenses", len(expenses))
+	logger.Debug("Searching for expenses summing to 2020", "part2", part2, "reference", reference)
 
+	var result int
+	var found bool
+	switch {
+	case reference && part2:
+		result, found = referenceTriple(expenses)
+	case reference:
+		result, found = referencePair(expenses)
+	case part2:
+		sort.Ints(expenses)
+		result, found = findTriple(expenses)
+	default:
+		sort.Ints(expenses)
+		result, found = findPair(expenses)
+	}
+	if !found {
+		die(errors.New("No expenses sum to 2020"))
+	}
+	fmt.Println(result)
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+var test_data = []int{1721, 979, 366, 299, 675, 1456}
+
+func sorted(expenses []int) []int {
+	expenses = append([]int{}, expenses...)
+	sort.Ints(expenses)
+	return expenses
+}
+
+func TestFindPair(t *testing.T) {
+	result, found := findPair(sorted(test_data))
+	if !found || result != 514579 {
+		t.Log("Expected 514579, got", result, found)
+		t.Fail()
+	}
+
+	if result, found := findPair([]int{1, 2, 1010}); found {
+		t.Log("Expected no pair, got", result)
+		t.Fail()
+	}
+}
+
+func TestFindTriple(t *testing.T) {
+	result, found := findTriple(sorted(test_data))
+	if !found || result != 241861950 {
+		t.Log("Expected 241861950, got", result, found)
+		t.Fail()
+	}
+
+	if result, found := findTriple([]int{1, 2, 2016}); found {
+		t.Log("Expected no triple, got", result)
+		t.Fail()
+	}
+}
+
+func TestGenerateExpenses(t *testing.T) {
+	for _, size := range []int{1, 5, 200, 5000} {
+		lines, part1, part2 := generateExpenses(rand.New(rand.NewSource(int64(size))), size)
+		var expenses []int
+		for _, line := range lines {
+			expense, err := strconv.Atoi(line)
+			if err != nil {
+				t.Log("Error, generated a bad expense", line, err)
+				t.FailNow()
+			}
+			expenses = append(expenses, expense)
+		}
+
+		if pair, found := referencePair(expenses); !found || pair != part1 {
+			t.Log("Size", size, "expected pair", part1, "but the reference found", pair, found)
+			t.Fail()
+		}
+		if triple, found := referenceTriple(expenses); !found || triple != part2 {
+			t.Log("Size", size, "expected triple", part2, "but the reference found", triple, found)
+			t.Fail()
+		}
+		if pair, found := findPair(sorted(expenses)); !found || pair != part1 {
+			t.Log("Size", size, "expected pair", part1, "got", pair, found)
+			t.Fail()
+		}
+		if triple, found := findTriple(sorted(expenses)); !found || triple != part2 {
+			t.Log("Size", size, "expected triple", part2, "got", triple, found)
+			t.Fail()
+		}
+	}
+}
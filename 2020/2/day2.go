@@ -2,8 +2,12 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"math/rand"
 	"os"
 	"regexp"
 	"strconv"
@@ -17,11 +21,52 @@ type passwordData struct {
 	Password  string
 }
 
+/* Errors should be printed to STDERR not STDOUT */
+func die(err error) {
+	fmt.Fprintf(os.Stderr, "%s\n", err)
+	closeTrace()
+	os.Exit(1)
+}
+
+var logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+var tracer *slog.Logger
+
+var closeTrace = func() {}
+
+func setupLogging(verbose bool, veryVerbose bool, traceFile string) (func(), error) {
+	level := slog.LevelWarn
+	if verbose {
+		level = slog.LevelInfo
+	}
+	if veryVerbose {
+		level = slog.LevelDebug
+	}
+	logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	if traceFile == "" {
+		return func() {}, nil
+	}
+	file, err := os.Create(traceFile)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewWriter(file)
+	tracer = slog.New(slog.NewJSONHandler(buffered, nil))
+	return func() {
+		if err := buffered.Flush(); err != nil {
+			logger.Warn("Failed to write the trace", "file", traceFile, "err", err)
+		}
+		if err := file.Close(); err != nil {
+			logger.Warn("Failed to close the trace", "file", traceFile, "err", err)
+		}
+	}, nil
+}
+
 func readPasswords(filename string) []string {
 	var passwords []string
 	file, err := os.Open(filename)
 	if err != nil {
-		fmt.Println("File reading error", err)
+		logger.Error("File reading error", "err", err)
 		return nil
 	}
 
@@ -39,17 +84,17 @@ func parsePasswordLines(passwordLines []string) []passwordData {
 	re := regexp.MustCompile(`^(?P<min>\d+)-(?P<max>\d+) (?P<char>[a-zA-Z]): (?P<password>\w+)$`)
 	for _, line := range passwordLines {
 		if !re.MatchString(line) {
-			fmt.Println("Bad line: ", line)
+			logger.Warn("Bad line", "line", line)
 		} else {
 			subMatch := re.FindStringSubmatch(line)
 			min, err := strconv.Atoi(subMatch[1])
 			if err != nil {
-				fmt.Println("Unable to convert text to int", err)
+				logger.Error("Unable to convert text to int", "err", err)
 				return nil
 			}
 			max, err := strconv.Atoi(subMatch[2])
 			if err != nil {
-				fmt.Println("Unable to convert text to int", err)
+				logger.Error("Unable to convert text to int", "err", err)
 				return nil
 			}
 			passwords = append(passwords, passwordData{
@@ -67,9 +112,13 @@ func scanPasswords(passwords []passwordData) int {
 	var correct int
 	for _, entry := range passwords {
 		count := strings.Count(entry.Password, entry.Character)
-		if count >= entry.Min && count <= entry.Max {
+		valid := count >= entry.Min && count <= entry.Max
+		if valid {
 			correct++
 		}
+		if tracer != nil {
+			tracer.Info("password", "password", entry.Password, "character", entry.Character, "count", count, "valid", valid)
+		}
 	}
 	return correct
 }
@@ -79,47 +128,160 @@ func scanPasswordsNewPolicy(passwords []passwordData) int {
 	var first bool
 	var second bool
 	for _, entry := range passwords {
-		// Re-base to zero from one so it lines up with arrays being zero based
+		// Re-base to zero from one so it lines up with arrays being zero based,
+		// a position of 0 is before the password so never matches
 		min := entry.Min - 1
 		max := entry.Max - 1
-		if min >= len(entry.Password) {
+		if min < 0 || min >= len(entry.Password) {
 			first = false
 		} else {
 			first = strings.Contains(string(entry.Password[min]), entry.Character)
 		}
-		if max >= len(entry.Password) {
+		if max < 0 || max >= len(entry.Password) {
 			second = false
 		} else {
 			second = strings.Contains(string(entry.Password[max]), entry.Character)
 		}
-		if (first || second) && !(first && second) {
+		valid := (first || second) && !(first && second)
+		if valid {
 			correct++
 		}
+		if tracer != nil {
+			tracer.Info("password", "password", entry.Password, "character", entry.Character, "first", first, "second", second, "valid", valid)
+		}
 	}
 	return correct
 }
 
+/*
+ * Each line is built to pass or fail each policy, chosen at random, so the
+ * answers are known without running either policy over the result.
+ */
+func generatePasswordLine(random *rand.Rand, validCount bool, validPosition bool) string {
+	for {
+		min := 1 + random.Intn(10)
+		max := min + 1 + random.Intn(10)
+		length := max + random.Intn(6)
+		character := byte('a' + random.Intn(26))
+
+		/* Exactly one of the two positions holds the character for a valid position */
+		atMin := random.Intn(2) == 0
+		atMax := atMin != validPosition
+		var fixed int
+		for _, at := range []bool{atMin, atMax} {
+			if at {
+				fixed++
+			}
+		}
+
+		var counts []int
+		for count := fixed; count <= fixed+length-2; count++ {
+			if (count >= min && count <= max) == validCount {
+				counts = append(counts, count)
+			}
+		}
+		if len(counts) == 0 {
+			continue
+		}
+		count := counts[random.Intn(len(counts))]
+
+		password := make([]byte, length)
+		var others []int
+		for i := range password {
+			password[i] = byte('a' + (int(character-'a')+1+random.Intn(25))%26)
+			if i != min-1 && i != max-1 {
+				others = append(others, i)
+			}
+		}
+		if atMin {
+			password[min-1] = character
+		}
+		if atMax {
+			password[max-1] = character
+		}
+		random.Shuffle(len(others), func(i, j int) {
+			others[i], others[j] = others[j], others[i]
+		})
+		for _, i := range others[:count-fixed] {
+			password[i] = character
+		}
+		return fmt.Sprintf("%d-%d %c: %s", min, max, character, password)
+	}
+}
+
+func generatePasswords(random *rand.Rand, size int) ([]string, int, int) {
+	var lines []string
+	var validCounts, validPositions int
+	for i := 0; i < size; i++ {
+		validCount := random.Intn(2) == 0
+		validPosition := random.Intn(2) == 0
+		if validCount {
+			validCounts++
+		}
+		if validPosition {
+			validPositions++
+		}
+		lines = append(lines, generatePasswordLine(random, validCount, validPosition))
+	}
+	return lines, validCounts, validPositions
+}
+
+func writeGenerated(prefix string, lines []string, part1 int, part2 int) error {
+	if err := ioutil.WriteFile(prefix, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(prefix+".answers", []byte(fmt.Sprintf("%d\n%d\n", part1, part2)), 0644)
+}
+
 func main() {
 	var passwordLines []string
 	var fileName string
 	var passwords []passwordData
 	var part2 bool
 	var result int
+	var generate string
+	var seed int64
+	var size int
+	var verbose bool
+	var veryVerbose bool
+	var traceFile string
 
 	flag.StringVar(&fileName, "f", "input", "Input file")
+	flag.StringVar(&generate, "generate", "", "Write a random input to this file and its answers to the file plus .answers")
+	flag.Int64Var(&seed, "seed", 1, "Seed for -generate")
+	flag.IntVar(&size, "size", 1000, "Number of passwords for -generate")
+	flag.BoolVar(&verbose, "v", false, "Log progress to STDERR")
+	flag.BoolVar(&veryVerbose, "vv", false, "Log progress and details to STDERR")
+	flag.StringVar(&traceFile, "trace", "", "Write each password checked to this file as JSON lines")
 	flag.BoolVar(&part2, "2", false, "Compute part 2 of the exercise")
 	flag.Parse()
 
+	flush, err := setupLogging(verbose, veryVerbose, traceFile)
+	if err != nil {
+		die(err)
+	}
+	closeTrace = flush
+	defer closeTrace()
+
+	if generate != "" {
+		lines, part1Answer, part2Answer := generatePasswords(rand.New(rand.NewSource(seed)), size)
+		if err := writeGenerated(generate, lines, part1Answer, part2Answer); err != nil {
+			die(fmt.Errorf("File writing error %s", err))
+		}
+		return
+	}
+
 	passwordLines = readPasswords(fileName)
 	if nil == passwordLines {
-		fmt.Println("Input file was empty")
-		os.Exit(1)
+		die(errors.New("Input file was empty"))
 	}
+	logger.Info("Read passwords", "file", fileName, "lines", len(passwordLines))
 
 	passwords = parsePasswordLines(passwordLines)
 	if passwords == nil {
-		os.Exit(1)
+		die(fmt.Errorf("No passwords could be parsed from %s", fileName))
 	}
+	logger.Debug("Parsed passwords", "passwords", len(passwords), "bad_lines", len(passwordLines)-len(passwords))
 
 	if part2 {
 		result = scanPasswordsNewPolicy(passwords)
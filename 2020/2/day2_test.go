@@ -1,7 +1,9 @@
 package main
 
 import (
+	"math/rand"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -106,3 +108,43 @@ func TestScanPasswordsNewPolicy(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestGeneratePasswords(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		lines, part1, part2 := generatePasswords(rand.New(rand.NewSource(seed)), 500)
+		passwords := parsePasswordLines(lines)
+		if len(passwords) != len(lines) {
+			t.Log("Seed", seed, "generated unparseable lines", lines)
+			t.Fail()
+			continue
+		}
+		if result := scanPasswords(passwords); result != part1 {
+			t.Log("Seed", seed, "expected", part1, "valid counts, got", result)
+			t.Fail()
+		}
+		if result := scanPasswordsNewPolicy(passwords); result != part2 {
+			t.Log("Seed", seed, "expected", part2, "valid positions, got", result)
+			t.Fail()
+		}
+	}
+}
+
+func FuzzParsePasswordLines(f *testing.F) {
+	f.Add("1-3 a: abcde\n1-3 b: cdefg\n2-9 c: ccccccccc")
+	f.Add("1-10 j: vrfjljjwbsv\n1-2 broken")
+	f.Add("0-0 x: x")
+	f.Fuzz(func(t *testing.T, input string) {
+		lines := strings.Split(input, "\n")
+		passwords := parsePasswordLines(lines)
+		if len(passwords) > len(lines) {
+			t.Log("Error, more passwords than lines", passwords)
+			t.Fail()
+		}
+		valid := scanPasswords(passwords)
+		validPositions := scanPasswordsNewPolicy(passwords)
+		if valid < 0 || valid > len(passwords) || validPositions < 0 || validPositions > len(passwords) {
+			t.Log("Error, counts out of range", valid, validPositions, "for", len(passwords), "passwords")
+			t.Fail()
+		}
+	})
+}
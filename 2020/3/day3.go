@@ -2,16 +2,69 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"flag"
 	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
+/* Errors should be printed to STDERR not STDOUT */
+func die(err error) {
+	fmt.Fprintf(os.Stderr, "%s\n", err)
+	closeTrace()
+	os.Exit(1)
+}
+
+var logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+var tracer *slog.Logger
+
+var closeTrace = func() {}
+
+func setupLogging(verbose bool, veryVerbose bool, traceFile string) (func(), error) {
+	level := slog.LevelWarn
+	if verbose {
+		level = slog.LevelInfo
+	}
+	if veryVerbose {
+		level = slog.LevelDebug
+	}
+	logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	if traceFile == "" {
+		return func() {}, nil
+	}
+	file, err := os.Create(traceFile)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewWriter(file)
+	tracer = slog.New(slog.NewJSONHandler(buffered, nil))
+	return func() {
+		if err := buffered.Flush(); err != nil {
+			logger.Warn("Failed to write the trace", "file", traceFile, "err", err)
+		}
+		if err := file.Close(); err != nil {
+			logger.Warn("Failed to close the trace", "file", traceFile, "err", err)
+		}
+	}, nil
+}
+
 func readTrees(filename string) []string {
 	var trees []string
 	file, err := os.Open(filename)
 	if err != nil {
-		fmt.Println("File reading error", err)
+		logger.Error("File reading error", "err", err)
 		return nil
 	}
 
@@ -22,104 +75,488 @@ func readTrees(filename string) []string {
 	return trees
 }
 
-func parseTreeLines(treeLines []string) (int, map[int][]int) {
-	var trees = make(map[int][]int)
-	var counter int
-	var length int
+/*
+ * Trees are stored one bit per square, each row padded out to a whole number
+ * of words, so a lookup is a shift and a mask rather than a search
+ */
+type forest struct {
+	width  int
+	height int
+	stride int // words per row
+	bits   []uint64
+}
+
+func newForest(width int, height int) forest {
+	stride := (width + 63) / 64
+	return forest{
+		width:  width,
+		height: height,
+		stride: stride,
+		bits:   make([]uint64, stride*height),
+	}
+}
 
+func parseForest(treeLines []string) forest {
+	var width int
 	for _, line := range treeLines {
-		length = len(line)
-		for pos, item := range line {
-			if item == '#' {
-				trees[counter] = append(trees[counter], pos)
+		if len(line) > width {
+			width = len(line)
+		}
+	}
+
+	f := newForest(width, len(treeLines))
+	for row, line := range treeLines {
+		for column := 0; column < len(line); column++ {
+			if line[column] == '#' {
+				f.setTree(row, column)
 			}
 		}
-		counter++
 	}
-	return length, trees
+	return f
 }
 
-func hitTree(pos int, trees []int) bool {
-	for _, tree := range trees {
-		if pos == tree {
-			return true
-		}
+func (f forest) setTree(row int, column int) {
+	f.bits[row*f.stride+column/64] |= 1 << (column % 64)
+}
+
+/* The pattern repeats to the right, so wrap the column rather than copying rows */
+func (f forest) isTree(row int, column int) bool {
+	if f.width == 0 || row < 0 || row >= f.height {
+		return false
 	}
-	return false
+	column %= f.width
+	return f.bits[row*f.stride+column/64]&(1<<(column%64)) != 0
+}
+
+type slope struct {
+	right int
+	down  int
 }
 
-func extendTrees(length int, trees []int, position int) []int {
-	var newTrees []int
-	loop := 1
-	for _, i := range trees {
-		newTrees = append(newTrees, i)
+/* The slopes checked in part 2, part 1 being the second of them */
+var part2Slopes = []slope{{1, 1}, {3, 1}, {5, 1}, {7, 1}, {1, 2}}
+
+/* Going down by 0 would never leave the first row, and going left runs off the pattern */
+func checkSlope(right int, down int) error {
+	if right < 0 || down < 1 {
+		return fmt.Errorf("Bad slope %d,%d, right must be >= 0 and down >= 1", right, down)
 	}
-	for newTrees[len(newTrees)-1] < position {
-		for _, tree := range trees {
-			newTrees = append(newTrees, tree+(length*loop))
+	return nil
+}
+
+func parseSlopes(slopeList string) ([]slope, error) {
+	var slopes []slope
+	for _, field := range strings.Fields(slopeList) {
+		parts := strings.Split(field, ",")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Bad slope %q, expected right,down", field)
+		}
+		right, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, err
 		}
-		loop++
+		down, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		if err := checkSlope(right, down); err != nil {
+			return nil, err
+		}
+		slopes = append(slopes, slope{right, down})
 	}
-	return newTrees
+	if len(slopes) == 0 {
+		return nil, errors.New("No slopes given")
+	}
+	return slopes, nil
 }
 
-func scanTrees(length int, trees map[int][]int, right int, down int) int {
+func scanTrees(f forest, right int, down int) int {
 	var position int
 	var treeHits int
-	//for line, _ := range trees {
-	for line := 0; line < len(trees); line++ {
-		treeLine := trees[line]
-		if line%down != 0 {
-			continue
+	for line := 0; line < f.height; line += down {
+		tree := f.isTree(line, position)
+		if tree {
+			treeHits++
 		}
-		if position >= treeLine[len(treeLine)-1] {
-			treeLine = extendTrees(length, treeLine, position)
+		if tracer != nil {
+			tracer.Info("position", "right", right, "down", down, "row", line, "column", position, "tree", tree, "hits", treeHits)
 		}
-		if hitTree(position, treeLine) {
+		position += right
+	}
+	return treeHits
+}
+
+func scanSlopes(f forest, slopes []slope) []int {
+	var hits []int
+	for _, s := range slopes {
+		hits = append(hits, scanTrees(f, s.right, s.down))
+		logger.Debug("Scanned slope", "right", s.right, "down", s.down, "hits", hits[len(hits)-1])
+	}
+	return hits
+}
+
+/*
+ * Reads the squares straight from the lines, padding each row to the widest
+ * and repeating it until the path is on it, to check scanTrees against
+ */
+func scanTreesReference(treeLines []string, right int, down int) int {
+	var width int
+	for _, line := range treeLines {
+		if len(line) > width {
+			width = len(line)
+		}
+	}
+	var treeHits int
+	for line, column := 0, 0; line < len(treeLines); line, column = line+down, column+right {
+		pattern := treeLines[line] + strings.Repeat(".", width-len(treeLines[line]))
+		row := pattern
+		for len(row) > 0 && len(row) <= column {
+			row += pattern
+		}
+		if column < len(row) && row[column] == '#' {
 			treeHits++
 		}
-		position += right
 	}
 	return treeHits
 }
 
+func reportHits(slopes []slope, hits []int, report string) ([]string, error) {
+	switch report {
+	case "product":
+		result := 1
+		for _, hit := range hits {
+			result *= hit
+		}
+		return []string{strconv.Itoa(result)}, nil
+	case "sum":
+		result := 0
+		for _, hit := range hits {
+			result += hit
+		}
+		return []string{strconv.Itoa(result)}, nil
+	case "list":
+		var lines []string
+		for i, s := range slopes {
+			lines = append(lines, fmt.Sprintf("%d,%d %d", s.right, s.down, hits[i]))
+		}
+		return lines, nil
+	}
+	return nil, fmt.Errorf("Unknown report %q, expected product, sum or list", report)
+}
+
+func parseWindow(window string, height int) (int, int, error) {
+	start, end := 0, height
+	if window == "" {
+		return start, end, nil
+	}
+	parts := strings.Split(window, ":")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Bad window %q, expected start:end", window)
+	}
+	var err error
+	if parts[0] != "" {
+		if start, err = strconv.Atoi(parts[0]); err != nil {
+			return 0, 0, err
+		}
+	}
+	if parts[1] != "" {
+		if end, err = strconv.Atoi(parts[1]); err != nil {
+			return 0, 0, err
+		}
+	}
+	if end > height {
+		end = height
+	}
+	if start < 0 || start >= end {
+		return 0, 0, fmt.Errorf("Bad window %q for %d rows", window, height)
+	}
+	return start, end, nil
+}
+
+/*
+ * Draw rows start to end of the forest with every slope's path on top, using
+ * the puzzle's markers: O for an open square visited and X for a tree hit.
+ * Unless wrapped, the pattern is repeated far enough right to fit the path.
+ */
+func renderPath(f forest, slopes []slope, start int, end int, wrap bool) [][]byte {
+	visited := make(map[[2]int]bool)
+	width := f.width
+	for _, s := range slopes {
+		for line := 0; line < end; line += s.down {
+			if line < start {
+				continue
+			}
+			column := (line / s.down) * s.right
+			if wrap && f.width > 0 {
+				column %= f.width
+			}
+			visited[[2]int{line, column}] = true
+			if column >= width {
+				width = column + 1
+			}
+		}
+	}
+	if f.width > 0 && width%f.width != 0 {
+		width += f.width - width%f.width
+	}
+
+	var rows [][]byte
+	for line := start; line < end; line++ {
+		row := make([]byte, width)
+		for column := range row {
+			tree := f.isTree(line, column)
+			switch {
+			case visited[[2]int{line, column}] && tree:
+				row[column] = 'X'
+			case visited[[2]int{line, column}]:
+				row[column] = 'O'
+			case tree:
+				row[column] = '#'
+			default:
+				row[column] = '.'
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+var renderColors = map[byte]color.RGBA{
+	'.': {0xff, 0xff, 0xff, 0xff},
+	'#': {0x22, 0x8b, 0x22, 0xff},
+	'O': {0x64, 0x95, 0xed, 0xff},
+	'X': {0xdc, 0x14, 0x3c, 0xff},
+}
+
+const renderScale = 4
+
+func writeSVG(w io.Writer, rows [][]byte) error {
+	var width int
+	if len(rows) > 0 {
+		width = len(rows[0])
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\">\n",
+		width*renderScale, len(rows)*renderScale)
+	fmt.Fprintf(bw, "<rect width=\"100%%\" height=\"100%%\" fill=\"#%02x%02x%02x\"/>\n",
+		renderColors['.'].R, renderColors['.'].G, renderColors['.'].B)
+	for y, row := range rows {
+		for x, square := range row {
+			if square == '.' {
+				continue
+			}
+			c := renderColors[square]
+			fmt.Fprintf(bw, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"#%02x%02x%02x\"/>\n",
+				x*renderScale, y*renderScale, renderScale, renderScale, c.R, c.G, c.B)
+		}
+	}
+	fmt.Fprintln(bw, "</svg>")
+	return bw.Flush()
+}
+
+func writePNG(w io.Writer, rows [][]byte) error {
+	var width int
+	if len(rows) > 0 {
+		width = len(rows[0])
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width*renderScale, len(rows)*renderScale))
+	for y, row := range rows {
+		for x, square := range row {
+			cell := image.Rect(x*renderScale, y*renderScale, (x+1)*renderScale, (y+1)*renderScale)
+			draw.Draw(img, cell, &image.Uniform{renderColors[square]}, image.Point{}, draw.Src)
+		}
+	}
+	return png.Encode(w, img)
+}
+
+func writeRendering(fileName string, rows [][]byte) error {
+	var write func(io.Writer, [][]byte) error
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".svg":
+		write = writeSVG
+	case ".png":
+		write = writePNG
+	default:
+		return fmt.Errorf("Unknown render format for %s, expected .svg or .png", fileName)
+	}
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := write(file, rows); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+/*
+ * Squares are decided as each part 2 slope first passes over them, tallying
+ * hits for every slope that lands there, so the answers come from building the
+ * forest rather than scanning it. Squares no slope visits are filled in after.
+ */
+func generateForest(random *rand.Rand, width int, height int) ([]string, int, int) {
+	grid := make([][]byte, height)
+	for row := range grid {
+		grid[row] = make([]byte, width)
+	}
+	plant := func() byte {
+		if random.Intn(4) == 0 {
+			return '#'
+		}
+		return '.'
+	}
+
+	hits := make([]int, len(part2Slopes))
+	for i, s := range part2Slopes {
+		for step := 0; step*s.down < height; step++ {
+			square := &grid[step*s.down][step*s.right%width]
+			if *square == 0 {
+				*square = plant()
+			}
+			if *square == '#' {
+				hits[i]++
+			}
+		}
+	}
+
+	var lines []string
+	for _, row := range grid {
+		for column := range row {
+			if row[column] == 0 {
+				row[column] = plant()
+			}
+		}
+		lines = append(lines, string(row))
+	}
+	product := 1
+	for _, hit := range hits {
+		product *= hit
+	}
+	return lines, hits[1], product
+}
+
+func writeGenerated(prefix string, lines []string, part1 int, part2 int) error {
+	if err := ioutil.WriteFile(prefix, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(prefix+".answers", []byte(fmt.Sprintf("%d\n%d\n", part1, part2)), 0644)
+}
+
 func main() {
 	var treeLines []string
 	var fileName string
-	var result int
 	var down int
 	var right int
-	var trees = make(map[int][]int)
-	var length int
+	var slopeList string
+	var report string
+	var slopes []slope
+	var render bool
+	var window string
+	var wrap bool
+	var renderFile string
+	var generate string
+	var seed int64
+	var size string
+	var reference bool
 	var part2 bool
+	var verbose bool
+	var veryVerbose bool
+	var traceFile string
+	var err error
 
 	flag.StringVar(&fileName, "f", "input", "Input file")
 	flag.IntVar(&down, "d", 1, "Points to travel down")
 	flag.IntVar(&right, "r", 3, "Points to travel right")
+	flag.StringVar(&slopeList, "slopes", "", "Space separated right,down slopes, e.g. \"1,1 3,1 5,1\"")
+	flag.StringVar(&report, "report", "product", "How to report multiple slopes: product, sum or list")
+	flag.BoolVar(&render, "render", false, "Draw the forest with the path overlaid instead of counting trees")
+	flag.StringVar(&window, "window", "", "Rows to render as start:end, defaults to all of them")
+	flag.BoolVar(&wrap, "wrap", false, "Render the path wrapped onto a single copy of the pattern")
+	flag.StringVar(&renderFile, "render-file", "", "Write the rendering to a .svg or .png file instead of the terminal")
+	flag.StringVar(&generate, "generate", "", "Write a random input to this file and its answers to the file plus .answers")
+	flag.Int64Var(&seed, "seed", 1, "Seed for -generate")
+	flag.StringVar(&size, "size", "31x323", "Width and height of the forest for -generate")
+	flag.BoolVar(&reference, "reference", false, "Count trees from the lines rather than the bitset, for cross checking")
+	flag.BoolVar(&verbose, "v", false, "Log progress to STDERR")
+	flag.BoolVar(&veryVerbose, "vv", false, "Log progress and details to STDERR")
+	flag.StringVar(&traceFile, "trace", "", "Write each toboggan position to this file as JSON lines")
 	flag.BoolVar(&part2, "2", false, "Compute part 2 of the exercise")
 	flag.Parse()
 
+	flush, err := setupLogging(verbose, veryVerbose, traceFile)
+	if err != nil {
+		die(err)
+	}
+	closeTrace = flush
+	defer closeTrace()
+
+	if generate != "" {
+		var width, height int
+		if _, err := fmt.Sscanf(size, "%dx%d", &width, &height); err != nil || width < 1 || height < 1 {
+			die(fmt.Errorf("Bad size %q, expected WIDTHxHEIGHT", size))
+		}
+		lines, part1Answer, part2Answer := generateForest(rand.New(rand.NewSource(seed)), width, height)
+		if err := writeGenerated(generate, lines, part1Answer, part2Answer); err != nil {
+			die(err)
+		}
+		return
+	}
+
 	treeLines = readTrees(fileName)
 	if nil == treeLines {
-		fmt.Println("Input file was empty")
-		os.Exit(1)
+		die(errors.New("Input file was empty"))
 	}
 
-	length, trees = parseTreeLines(treeLines)
-	if trees == nil {
-		os.Exit(1)
+	trees := parseForest(treeLines)
+	logger.Info("Read forest", "file", fileName, "width", trees.width, "height", trees.height)
+
+	switch {
+	case slopeList != "":
+		slopes, err = parseSlopes(slopeList)
+		if err != nil {
+			die(err)
+		}
+	case part2:
+		slopes = part2Slopes
+	default:
+		if err := checkSlope(right, down); err != nil {
+			die(err)
+		}
+		slopes = []slope{{right, down}}
 	}
 
-	if part2 {
-		result = 1
-		result *= scanTrees(length, trees, 1, 1)
-		result *= scanTrees(length, trees, 3, 1)
-		result *= scanTrees(length, trees, 5, 1)
-		result *= scanTrees(length, trees, 7, 1)
-		result *= scanTrees(length, trees, 1, 2)
-	} else {
-		result = scanTrees(length, trees, right, down)
+	if render {
+		start, end, err := parseWindow(window, trees.height)
+		if err != nil {
+			die(err)
+		}
+		rows := renderPath(trees, slopes, start, end, wrap)
+		if renderFile != "" {
+			if err := writeRendering(renderFile, rows); err != nil {
+				die(err)
+			}
+			return
+		}
+		for _, row := range rows {
+			fmt.Println(string(row))
+		}
+		return
 	}
 
-	fmt.Println(result)
+	hits := scanSlopes(trees, slopes)
+	if reference {
+		hits = nil
+		for _, s := range slopes {
+			hits = append(hits, scanTreesReference(treeLines, s.right, s.down))
+		}
+	}
+	lines, err := reportHits(slopes, hits, report)
+	if err != nil {
+		die(err)
+	}
+	for _, line := range lines {
+		fmt.Println(line)
+	}
 }
@@ -1,74 +1,100 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"image/png"
+	"log/slog"
+	"math/rand"
 	"reflect"
+	"strings"
 	"testing"
 )
 
-func TestParseTreeLines(t *testing.T) {
+func TestParseForest(t *testing.T) {
 	data := []string{
 		".#.#.#",
 		".....#",
 	}
-	expected := map[int][]int{
-		0: {1, 3, 5},
-		1: {5},
-	}
-	length, result := parseTreeLines(data)
+	trees := parseForest(data)
 
-	if !reflect.DeepEqual(result, expected) {
-		t.Log("Error, tree map wrong, got", result)
+	if trees.width != 6 {
+		t.Log("Error, expect width 6, got", trees.width)
 		t.Fail()
 	}
-	if length != 6 {
-		t.Log("Error, expect length 5, got", length)
+	if trees.height != 2 {
+		t.Log("Error, expect height 2, got", trees.height)
 		t.Fail()
 	}
-}
-
-func TestHitTreeHit(t *testing.T) {
-	position := 2
-	trees := []int{2, 4, 6}
-
-	if !hitTree(position, trees) {
-		t.Log("Error, expect to hit tree, did not")
+	expected := []uint64{0x2a, 0x20}
+	if !reflect.DeepEqual(trees.bits, expected) {
+		t.Log("Error, tree bits wrong, got", trees.bits)
 		t.Fail()
 	}
 }
 
-func TestHitTreeMiss(t *testing.T) {
-	position := 3
-	trees := []int{2, 4, 6}
+func TestParseForestWide(t *testing.T) {
+	line := make([]byte, 130)
+	for i := range line {
+		line[i] = '.'
+	}
+	line[0] = '#'
+	line[64] = '#'
+	line[129] = '#'
+	trees := parseForest([]string{string(line)})
 
-	if hitTree(position, trees) {
-		t.Log("Error, expect to miss tree, did not")
+	if trees.stride != 3 {
+		t.Log("Error, expect stride 3, got", trees.stride)
 		t.Fail()
 	}
+	for column := range line {
+		if trees.isTree(0, column) != (line[column] == '#') {
+			t.Log("Error, wrong tree state at column", column)
+			t.Fail()
+		}
+	}
 }
 
-func TestExtendTrees(t *testing.T) {
-	length := 6
-	trees := []int{1, 3, 5}
-	position := 15
-	expected := []int{1, 3, 5, 7, 9, 11, 13, 15, 17}
+func TestForestIsTree(t *testing.T) {
+	trees := parseForest([]string{
+		".#.#.#",
+		".....#",
+	})
 
-	result := extendTrees(length, trees, position)
-	if !reflect.DeepEqual(result, expected) {
-		t.Log("Error, unexpected extension, got", result)
+	if !trees.isTree(0, 1) {
+		t.Log("Error, expect tree at 0,1")
+		t.Fail()
+	}
+	if trees.isTree(0, 2) {
+		t.Log("Error, expect no tree at 0,2")
+		t.Fail()
+	}
+	// Wraps around to column 5
+	if !trees.isTree(1, 17) {
+		t.Log("Error, expect wrapped tree at 1,17")
+		t.Fail()
+	}
+	if trees.isTree(1, 16) {
+		t.Log("Error, expect no wrapped tree at 1,16")
+		t.Fail()
+	}
+	if trees.isTree(2, 5) {
+		t.Log("Error, expect no tree below the forest")
 		t.Fail()
 	}
 }
 
 func TestScanTrees(t *testing.T) {
-	length := 6
-	trees := map[int][]int{
-		0: {1, 3, 5},
-		1: {5},
-	}
+	trees := parseForest([]string{
+		".#.#.#",
+		".....#",
+	})
 	right := 5
 	down := 1
 
-	hits := scanTrees(length, trees, right, down)
+	hits := scanTrees(trees, right, down)
 	if hits != 1 {
 		t.Log("Error, expect 1 hits, got", hits)
 		t.Fail()
@@ -76,23 +102,22 @@ func TestScanTrees(t *testing.T) {
 }
 
 func TestScanTreesExtension(t *testing.T) {
-	length := 6
 	/*
 		.#.#.#.#.#.#.#.#.#.#.#.#
 		....#.....#.....#.....#.
 		.#..#..#..#..#..#..#..#.
 		....#.....#.....#.....#.
 	*/
-	trees := map[int][]int{
-		0: {1, 3, 5},
-		1: {4},
-		2: {1, 4},
-		3: {4},
-	}
+	trees := parseForest([]string{
+		".#.#.#",
+		"....#.",
+		".#..#.",
+		"....#.",
+	})
 	right := 5
 	down := 1
 
-	hits := scanTrees(length, trees, right, down)
+	hits := scanTrees(trees, right, down)
 	if hits != 1 {
 		t.Log("Error, expect 1 hits, got", hits)
 		t.Fail()
@@ -100,7 +125,6 @@ func TestScanTreesExtension(t *testing.T) {
 }
 
 func TestScanTreesMultipleExtensionNoHit(t *testing.T) {
-	length := 3
 	/*
 		..#..#..#..#..#..#..#..#
 		.#..#..#..#..#..#..#..#.
@@ -112,21 +136,15 @@ func TestScanTreesMultipleExtensionNoHit(t *testing.T) {
 		.#..#..#..#..#..#..#..#.
 		#..#..#..#..#..#..#..#..
 	*/
-	trees := map[int][]int{
-		0: {2},
-		1: {1},
-		2: {0},
-		3: {2},
-		4: {1},
-		5: {0},
-		6: {2},
-		7: {1},
-		8: {0},
-	}
+	trees := parseForest([]string{
+		"..#", ".#.", "#..",
+		"..#", ".#.", "#..",
+		"..#", ".#.", "#..",
+	})
 	right := 2
 	down := 1
 
-	hits := scanTrees(length, trees, right, down)
+	hits := scanTrees(trees, right, down)
 	if hits != 0 {
 		t.Log("Error, expect 0 hits, got", hits)
 		t.Fail()
@@ -134,7 +152,6 @@ func TestScanTreesMultipleExtensionNoHit(t *testing.T) {
 }
 
 func TestScanTreesMultipleExtensionHit(t *testing.T) {
-	length := 3
 	/*
 		..#..#..#..#..#..#..#..#
 		.#..#..#..#..#..#..#..#.
@@ -146,23 +163,409 @@ func TestScanTreesMultipleExtensionHit(t *testing.T) {
 		.#..#..#..#..#..#..#..#.
 		#..#..#..#..#..#..#..#..
 	*/
-	trees := map[int][]int{
-		0: {2},
-		1: {1},
-		2: {0},
-		3: {2},
-		4: {1},
-		5: {0},
-		6: {2},
-		7: {1},
-		8: {0},
-	}
+	trees := parseForest([]string{
+		"..#", ".#.", "#..",
+		"..#", ".#.", "#..",
+		"..#", ".#.", "#..",
+	})
 	right := 3
 	down := 1
 
-	hits := scanTrees(length, trees, right, down)
+	hits := scanTrees(trees, right, down)
 	if hits != 3 {
 		t.Log("Error, expect 3 hits, got", hits)
 		t.Fail()
 	}
 }
+
+func TestScanTreesEmptyRow(t *testing.T) {
+	trees := parseForest([]string{
+		"..#",
+		"...",
+		".#.",
+	})
+
+	hits := scanTrees(trees, 1, 1)
+	if hits != 0 {
+		t.Log("Error, expect 0 hits, got", hits)
+		t.Fail()
+	}
+	hits = scanTrees(trees, 2, 1)
+	if hits != 1 {
+		t.Log("Error, expect 1 hits, got", hits)
+		t.Fail()
+	}
+}
+
+func TestParseSlopes(t *testing.T) {
+	slopes, err := parseSlopes("1,1 3,1  1,2")
+	if err != nil {
+		t.Log("Unexpected error:", err)
+		t.Fail()
+	}
+	expected := []slope{{1, 1}, {3, 1}, {1, 2}}
+	if !reflect.DeepEqual(slopes, expected) {
+		t.Log("Error, unexpected slopes, got", slopes)
+		t.Fail()
+	}
+
+	for _, bad := range []string{"", "1", "1,x", "x,1", "1,0", "1,1,1"} {
+		if slopes, err = parseSlopes(bad); err == nil {
+			t.Log("Expected error parsing", bad, "got", slopes)
+			t.Fail()
+		}
+	}
+}
+
+func TestCheckSlope(t *testing.T) {
+	for _, good := range []slope{{0, 1}, {3, 1}, {1, 2}} {
+		if err := checkSlope(good.right, good.down); err != nil {
+			t.Log("Unexpected error for", good, err)
+			t.Fail()
+		}
+	}
+	for _, bad := range []slope{{3, 0}, {-1, 1}, {1, -2}} {
+		if err := checkSlope(bad.right, bad.down); err == nil {
+			t.Log("Expected error for", bad)
+			t.Fail()
+		}
+	}
+}
+
+func TestReportHits(t *testing.T) {
+	slopes := []slope{{1, 1}, {3, 1}}
+	hits := []int{2, 7}
+
+	result, err := reportHits(slopes, hits, "product")
+	if err != nil || !reflect.DeepEqual(result, []string{"14"}) {
+		t.Log("Error, expected product 14, got", result, err)
+		t.Fail()
+	}
+
+	result, err = reportHits(slopes, hits, "sum")
+	if err != nil || !reflect.DeepEqual(result, []string{"9"}) {
+		t.Log("Error, expected sum 9, got", result, err)
+		t.Fail()
+	}
+
+	result, err = reportHits(slopes, hits, "list")
+	if err != nil || !reflect.DeepEqual(result, []string{"1,1 2", "3,1 7"}) {
+		t.Log("Error, unexpected list, got", result, err)
+		t.Fail()
+	}
+
+	if _, err = reportHits(slopes, hits, "median"); err == nil {
+		t.Log("Expected error for unknown report")
+		t.Fail()
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	start, end, err := parseWindow("", 10)
+	if err != nil || start != 0 || end != 10 {
+		t.Log("Error, expected whole forest, got", start, end, err)
+		t.Fail()
+	}
+
+	start, end, err = parseWindow("2:", 10)
+	if err != nil || start != 2 || end != 10 {
+		t.Log("Error, expected 2:10, got", start, end, err)
+		t.Fail()
+	}
+
+	start, end, err = parseWindow(":20", 10)
+	if err != nil || start != 0 || end != 10 {
+		t.Log("Error, expected end clamped to 10, got", start, end, err)
+		t.Fail()
+	}
+
+	for _, bad := range []string{"5", "a:", ":b", "5:5", "-1:3", "10:"} {
+		if _, _, err = parseWindow(bad, 10); err == nil {
+			t.Log("Expected error parsing window", bad)
+			t.Fail()
+		}
+	}
+}
+
+func TestRenderPath(t *testing.T) {
+	trees := parseForest([]string{
+		"..#",
+		"#..",
+		".#.",
+	})
+
+	rows := renderPath(trees, []slope{{2, 1}}, 0, 3, false)
+	expected := []string{
+		"O.#..#",
+		"#.O#..",
+		".#..X.",
+	}
+	for i, row := range rows {
+		if string(row) != expected[i] {
+			t.Log("Error, row", i, "expected", expected[i], "got", string(row))
+			t.Fail()
+		}
+	}
+
+	rows = renderPath(trees, []slope{{2, 1}}, 1, 3, true)
+	expected = []string{
+		"#.O",
+		".X.",
+	}
+	if len(rows) != len(expected) {
+		t.Log("Error, expected 2 rows, got", len(rows))
+		t.FailNow()
+	}
+	for i, row := range rows {
+		if string(row) != expected[i] {
+			t.Log("Error, wrapped row", i, "expected", expected[i], "got", string(row))
+			t.Fail()
+		}
+	}
+}
+
+func TestWriteSVG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeSVG(&buf, [][]byte{[]byte(".#"), []byte("OX")}); err != nil {
+		t.Log("Unexpected error:", err)
+		t.Fail()
+	}
+	svg := buf.String()
+	if !strings.HasPrefix(svg, "<svg") || !strings.HasSuffix(svg, "</svg>\n") {
+		t.Log("Error, not an SVG document:", svg)
+		t.Fail()
+	}
+	// Background plus one rectangle for each non-open square
+	if count := strings.Count(svg, "<rect"); count != 4 {
+		t.Log("Error, expected 4 rectangles, got", count)
+		t.Fail()
+	}
+}
+
+func TestWritePNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writePNG(&buf, [][]byte{[]byte(".#"), []byte("OX")}); err != nil {
+		t.Log("Unexpected error:", err)
+		t.Fail()
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Log("Failed to decode PNG:", err)
+		t.FailNow()
+	}
+	if img.Bounds().Dx() != 2*renderScale || img.Bounds().Dy() != 2*renderScale {
+		t.Log("Error, unexpected image size", img.Bounds())
+		t.Fail()
+	}
+	if img.At(renderScale, renderScale) != color.Color(renderColors['X']) {
+		t.Log("Error, expected a tree hit colour, got", img.At(renderScale, renderScale))
+		t.Fail()
+	}
+}
+
+/* Roughly one square in four is a tree, filled a word at a time to keep large forests quick to build */
+func syntheticForest(width int, height int) forest {
+	random := rand.New(rand.NewSource(3))
+	f := newForest(width, height)
+	for i := range f.bits {
+		f.bits[i] = random.Uint64() & random.Uint64()
+		if tail := width % 64; tail != 0 && i%f.stride == f.stride-1 {
+			f.bits[i] &= 1<<tail - 1
+		}
+	}
+	return f
+}
+
+/* The previous storage: a list of tree columns per row, searched linearly */
+func syntheticTreeLists(f forest) map[int][]int {
+	trees := make(map[int][]int)
+	for row := 0; row < f.height; row++ {
+		for column := 0; column < f.width; column++ {
+			if f.isTree(row, column) {
+				trees[row] = append(trees[row], column)
+			}
+		}
+	}
+	return trees
+}
+
+/*
+ * The previous scan, unchanged apart from its name, to benchmark against.
+ * It repeats a row's trees out to the position on every row it visits, and
+ * needs a tree in every row.
+ */
+func hitTree(pos int, trees []int) bool {
+	for _, tree := range trees {
+		if pos == tree {
+			return true
+		}
+	}
+	return false
+}
+
+func extendTrees(length int, trees []int, position int) []int {
+	var newTrees []int
+	loop := 1
+	for _, i := range trees {
+		newTrees = append(newTrees, i)
+	}
+	for newTrees[len(newTrees)-1] < position {
+		for _, tree := range trees {
+			newTrees = append(newTrees, tree+(length*loop))
+		}
+		loop++
+	}
+	return newTrees
+}
+
+func scanTreeLists(length int, trees map[int][]int, right int, down int) int {
+	var position int
+	var treeHits int
+	for line := 0; line < len(trees); line++ {
+		treeLine := trees[line]
+		if line%down != 0 {
+			continue
+		}
+		if position >= treeLine[len(treeLine)-1] {
+			treeLine = extendTrees(length, treeLine, position)
+		}
+		if hitTree(position, treeLine) {
+			treeHits++
+		}
+		position += right
+	}
+	return treeHits
+}
+
+/*
+ * Both on the same forests; the lists slow down with every row as the
+ * extensions grow, so only the bitset goes on to millions of rows
+ */
+var benchmarkHeights = []int{1 << 10, 1 << 12, 1 << 14}
+
+var largeBenchmarkHeights = []int{1 << 20, 1 << 22}
+
+func BenchmarkScanTreesLists(b *testing.B) {
+	for _, height := range benchmarkHeights {
+		f := syntheticForest(2048, height)
+		trees := syntheticTreeLists(f)
+		b.Run(fmt.Sprintf("2048x%d", height), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				scanTreeLists(f.width, trees, 3, 1)
+			}
+		})
+	}
+}
+
+func BenchmarkScanTreesBitset(b *testing.B) {
+	for _, height := range append(benchmarkHeights, largeBenchmarkHeights...) {
+		f := syntheticForest(2048, height)
+		b.Run(fmt.Sprintf("2048x%d", height), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				scanTrees(f, 3, 1)
+			}
+		})
+	}
+}
+
+func TestScanTreesMatchesTreeLists(t *testing.T) {
+	f := syntheticForest(100, 500)
+	trees := syntheticTreeLists(f)
+	for _, s := range []slope{{1, 1}, {3, 1}, {5, 1}, {7, 1}, {1, 2}, {150, 3}} {
+		expected := scanTreeLists(f.width, trees, s.right, s.down)
+		if hits := scanTrees(f, s.right, s.down); hits != expected {
+			t.Log("Error, slope", s, "expected", expected, "hits, got", hits)
+			t.Fail()
+		}
+	}
+}
+
+func TestGenerateForest(t *testing.T) {
+	for _, size := range [][2]int{{31, 323}, {1, 5}, {7, 2}, {100, 40}} {
+		lines, part1, part2 := generateForest(rand.New(rand.NewSource(int64(size[0]))), size[0], size[1])
+		f := parseForest(lines)
+		if f.width != size[0] || f.height != size[1] {
+			t.Log("Expected a", size, "forest, got", f.width, f.height)
+			t.Fail()
+		}
+		if hits := scanTrees(f, 3, 1); hits != part1 {
+			t.Log("Expected", part1, "hits in", size, "forest, got", hits)
+			t.Fail()
+		}
+		product := 1
+		for _, hits := range scanSlopes(f, part2Slopes) {
+			product *= hits
+		}
+		if product != part2 {
+			t.Log("Expected", part2, "for part 2 of", size, "forest, got", product)
+			t.Fail()
+		}
+	}
+}
+
+func FuzzParseForest(f *testing.F) {
+	f.Add("..##.......\n#...#...#..\n.#....#..#.")
+	f.Add(".#.#.#\n.....#\n")
+	f.Add("\n\n#")
+	f.Fuzz(func(t *testing.T, input string) {
+		lines := strings.Split(input, "\n")
+		trees := parseForest(lines)
+		for row, line := range lines {
+			for column := 0; column < len(line); column++ {
+				if trees.isTree(row, column) != (line[column] == '#') {
+					t.Log("Error, square", row, column, "of", lines, "read wrong")
+					t.Fail()
+				}
+			}
+		}
+		for _, hits := range scanSlopes(trees, part2Slopes) {
+			if hits < 0 || hits > trees.height {
+				t.Log("Error, more hits than rows", hits, "in", lines)
+				t.Fail()
+			}
+		}
+		for _, s := range part2Slopes {
+			if hits, expected := scanTrees(trees, s.right, s.down), scanTreesReference(lines, s.right, s.down); hits != expected {
+				t.Log("Error, slope", s, "hit", hits, "trees but the reference hit", expected, "in", lines)
+				t.Fail()
+			}
+		}
+		if rows := renderPath(trees, part2Slopes, 0, trees.height, true); len(rows) != trees.height {
+			t.Log("Error, rendered", len(rows), "rows of", trees.height)
+			t.Fail()
+		}
+	})
+}
+
+func TestScanTreesTrace(t *testing.T) {
+	var trace bytes.Buffer
+	tracer = slog.New(slog.NewJSONHandler(&trace, nil))
+	defer func() { tracer = nil }()
+
+	scanTrees(parseForest([]string{"..#", "#..", ".#."}), 2, 1)
+	type position struct {
+		Msg    string `json:"msg"`
+		Row    int    `json:"row"`
+		Column int    `json:"column"`
+		Tree   bool   `json:"tree"`
+		Hits   int    `json:"hits"`
+	}
+	var positions []position
+	for _, line := range strings.Split(strings.TrimSpace(trace.String()), "\n") {
+		var p position
+		if err := json.Unmarshal([]byte(line), &p); err != nil {
+			t.Log("Error, bad trace line", line, err)
+			t.FailNow()
+		}
+		positions = append(positions, p)
+	}
+	expected := []position{
+		{"position", 0, 0, false, 0},
+		{"position", 1, 2, false, 0},
+		{"position", 2, 4, true, 1},
+	}
+	if !reflect.DeepEqual(positions, expected) {
+		t.Log("Error, traced", positions)
+		t.Fail()
+	}
+}
@@ -1,34 +1,75 @@
 package main
 
 import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log/slog"
+	"math/rand"
 	"os"
 	"regexp"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 type Passport struct {
-	birthYear  int    // byr
-	issueYear  int    // iyr
-	expireYear int    // eyr
-	height     string // hgt
-	hairColor  string // hcl
-	eyeColor   string // ecl
-	passportID string // pid, can have a leading zero, so int is a bad idea
-	countryID  int    // cid, optional in part 1
+	fields     map[string]string // checked against the rules, so new keys need no code
+	duplicates []string          // keys given more than once, the last value wins
+	startLine  int               // first and last lines of the record in the input, from 1
+	endLine    int
+	violations []violation
 	valid      bool
 }
 
 /* Errors should be printed to STDERR not STDOUT */
 func die(err error) {
 	fmt.Fprintf(os.Stderr, "%s\n", err)
+	closeTrace()
 	os.Exit(1)
 }
 
+var logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+var tracer *slog.Logger
+
+var closeTrace = func() {}
+
+func setupLogging(verbose bool, veryVerbose bool, traceFile string) (func(), error) {
+	level := slog.LevelWarn
+	if verbose {
+		level = slog.LevelInfo
+	}
+	if veryVerbose {
+		level = slog.LevelDebug
+	}
+	logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	if traceFile == "" {
+		return func() {}, nil
+	}
+	file, err := os.Create(traceFile)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewWriter(file)
+	tracer = slog.New(slog.NewJSONHandler(buffered, nil))
+	return func() {
+		if err := buffered.Flush(); err != nil {
+			logger.Warn("Failed to write the trace", "file", traceFile, "err", err)
+		}
+		if err := file.Close(); err != nil {
+			logger.Warn("Failed to close the trace", "file", traceFile, "err", err)
+		}
+	}, nil
+}
+
 func readFile(filename string) ([]string, error) {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
@@ -43,186 +84,697 @@ func readFile(filename string) ([]string, error) {
 	return lines, nil
 }
 
-func validBirthYear(year int) bool {
-	if year >= 1920 && year <= 2002 {
-		return true
-	}
-	return false
+/*
+ * A rules file lists each field a record may contain. Every field has a key
+ * and may be required; in strict mode its value must also satisfy its type:
+ *   int   - a whole number between min and max
+ *   unit  - a whole number followed by one of the units, each with a range
+ *   regex - matches pattern
+ *   enum  - one of values
+ * A field with no type accepts any value.
+ */
+type valueRange struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
 }
 
-func validIssueYear(year int) bool {
-	if year >= 2010 && year <= 2020 {
-		return true
-	}
-	return false
+type fieldRule struct {
+	Key      string                `json:"key"`
+	Required bool                  `json:"required"`
+	Type     string                `json:"type,omitempty"`
+	Min      int                   `json:"min,omitempty"`
+	Max      int                   `json:"max,omitempty"`
+	Units    map[string]valueRange `json:"units,omitempty"`
+	Pattern  string                `json:"pattern,omitempty"`
+	Values   []string              `json:"values,omitempty"`
+
+	/* Compiled by loadRules so checking a value does no set up */
+	pattern *regexp.Regexp
+	units   []string // longest first
+	values  map[string]bool
 }
 
-func validExpirationYear(year int) bool {
-	if year >= 2020 && year <= 2030 {
-		return true
-	}
-	return false
+type ruleSet struct {
+	Fields []fieldRule `json:"fields"`
+	byKey  map[string]*fieldRule
 }
 
-func validHeight(height string) bool {
-	if len(height) < 4 {
-		return false
-	}
-	value, err := strconv.Atoi(height[:len(height)-2])
-	if err != nil {
-		// fmt.Fprintf(os.Stderr, "Failed to parse height: %s\n", err)
-		return false
+const defaultRulesJSON = `{
+	"fields": [
+		{"key": "byr", "required": true, "type": "int", "min": 1920, "max": 2002},
+		{"key": "iyr", "required": true, "type": "int", "min": 2010, "max": 2020},
+		{"key": "eyr", "required": true, "type": "int", "min": 2020, "max": 2030},
+		{"key": "hgt", "required": true, "type": "unit", "units": {
+			"cm": {"min": 150, "max": 193},
+			"in": {"min": 59, "max": 76}
+		}},
+		{"key": "hcl", "required": true, "type": "regex", "pattern": "^#[0-9a-f]{6}$"},
+		{"key": "ecl", "required": true, "type": "enum",
+			"values": ["amb", "blu", "brn", "gry", "grn", "hzl", "oth"]},
+		{"key": "pid", "required": true, "type": "regex", "pattern": "^[0-9]{9}$"},
+		{"key": "cid", "required": false}
+	]
+}`
+
+func loadRules(data []byte) (ruleSet, error) {
+	var rules ruleSet
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return rules, err
 	}
-	unit := height[len(height)-2:]
-	if unit == "cm" {
-		if value >= 150 && value <= 193 {
-			return true
+	rules.byKey = make(map[string]*fieldRule)
+	for i := range rules.Fields {
+		rule := &rules.Fields[i]
+		if rule.Key == "" {
+			return rules, fmt.Errorf("Rule %d has no key", i)
 		}
-	}
-	if unit == "in" {
-		if value >= 59 && value <= 76 {
-			return true
+		if _, ok := rules.byKey[rule.Key]; ok {
+			return rules, fmt.Errorf("Duplicate rule for %s", rule.Key)
+		}
+		switch rule.Type {
+		case "", "int":
+		case "enum":
+			rule.values = make(map[string]bool)
+			for _, value := range rule.Values {
+				rule.values[value] = true
+			}
+		case "unit":
+			if len(rule.Units) == 0 {
+				return rules, fmt.Errorf("Rule %s has no units", rule.Key)
+			}
+			/* Try the longest unit first so "cm" isn't read as a number of "m" */
+			for unit := range rule.Units {
+				rule.units = append(rule.units, unit)
+			}
+			sort.Slice(rule.units, func(i, j int) bool {
+				if len(rule.units[i]) != len(rule.units[j]) {
+					return len(rule.units[i]) > len(rule.units[j])
+				}
+				return rule.units[i] < rule.units[j]
+			})
+		case "regex":
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return rules, fmt.Errorf("Rule %s: %s", rule.Key, err)
+			}
+			rule.pattern = pattern
+		default:
+			return rules, fmt.Errorf("Rule %s has unknown type %q", rule.Key, rule.Type)
 		}
+		rules.byKey[rule.Key] = rule
 	}
-	return false
+	return rules, nil
 }
 
-func validHairColor(color string) bool {
-	valid := regexp.MustCompile(`^#[0-9a-f]{6}$`)
-	if valid.MatchString(color) {
-		return true
+func readRules(filename string) (ruleSet, error) {
+	if filename == "" {
+		return loadRules([]byte(defaultRulesJSON))
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return ruleSet{}, err
 	}
-	return false
+	return loadRules(data)
 }
 
-func validEyeColor(color string) bool {
-	valid := regexp.MustCompile(`^(amb|blu|brn|gry|grn|hzl|oth)$`)
-	if valid.MatchString(color) {
-		return true
+/* Kinds of violation a record can have */
+const (
+	violationMissing   = "missing"
+	violationRange     = "range"
+	violationFormat    = "format"
+	violationUnknown   = "unknown"
+	violationDuplicate = "duplicate"
+)
+
+type violation struct {
+	Kind  string `json:"kind"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+func (v violation) String() string {
+	if v.Value == "" {
+		return fmt.Sprintf("%s %s", v.Kind, v.Key)
 	}
-	return false
+	return fmt.Sprintf("%s %s:%s", v.Kind, v.Key, v.Value)
 }
 
-func validPassportNumber(passport string) bool {
-	valid := regexp.MustCompile(`^[0-9]{9}$`)
-	if valid.MatchString(passport) {
-		return true
+/* Returns the kind of violation, or an empty string if the value is fine */
+func (rule fieldRule) checkValue(value string) string {
+	switch rule.Type {
+	case "int":
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			logger.Debug("Failed to parse int", "key", rule.Key, "err", err)
+			return violationFormat
+		}
+		if number < rule.Min || number > rule.Max {
+			return violationRange
+		}
+	case "unit":
+		var unit string
+		for _, candidate := range rule.units {
+			if strings.HasSuffix(value, candidate) {
+				unit = candidate
+				break
+			}
+		}
+		if unit == "" {
+			logger.Debug("No known unit", "key", rule.Key, "value", value)
+			return violationFormat
+		}
+		number, err := strconv.Atoi(strings.TrimSuffix(value, unit))
+		if err != nil {
+			logger.Debug("Failed to parse the number before the unit", "key", rule.Key, "unit", unit, "err", err)
+			return violationFormat
+		}
+		limits := rule.Units[unit]
+		if number < limits.Min || number > limits.Max {
+			return violationRange
+		}
+	case "regex":
+		if !rule.pattern.MatchString(value) {
+			return violationFormat
+		}
+	case "enum":
+		if !rule.values[value] {
+			return violationFormat
+		}
 	}
-	return false
+	return ""
 }
 
-func checkValidPassport(passport *Passport, strict bool) {
+/*
+ * Missing required fields are always violations. Strict checking adds bad
+ * values and keys the rules don't mention, which are only reported.
+ */
+func (rules ruleSet) checkRecord(record map[string]string, strict bool) []violation {
+	var violations []violation
+	for _, rule := range rules.Fields {
+		value, ok := record[rule.Key]
+		if !ok || value == "" {
+			if rule.Required {
+				violations = append(violations, violation{Kind: violationMissing, Key: rule.Key})
+			}
+			continue
+		}
+		if !strict {
+			continue
+		}
+		if kind := rule.checkValue(value); kind != "" {
+			violations = append(violations, violation{Kind: kind, Key: rule.Key, Value: value})
+		}
+	}
 	if strict {
-		if validBirthYear(passport.birthYear) &&
-			validIssueYear(passport.issueYear) &&
-			validExpirationYear(passport.expireYear) &&
-			validHeight(passport.height) &&
-			validHairColor(passport.hairColor) &&
-			validEyeColor(passport.eyeColor) &&
-			validPassportNumber(passport.passportID) {
-			passport.valid = true
+		var unknown []string
+		for key := range record {
+			if _, ok := rules.byKey[key]; !ok {
+				unknown = append(unknown, key)
+			}
 		}
-	} else {
-		if passport.birthYear != 0 &&
-			passport.issueYear != 0 &&
-			passport.expireYear != 0 &&
-			passport.height != "" &&
-			passport.hairColor != "" &&
-			passport.eyeColor != "" &&
-			passport.passportID != "" {
-			passport.valid = true
+		sort.Strings(unknown)
+		for _, key := range unknown {
+			violations = append(violations, violation{Kind: violationUnknown, Key: key, Value: record[key]})
 		}
 	}
+	return violations
+}
+
+/* Unknown and duplicate keys are listed in reports, but like cid they don't make a record invalid */
+func (v violation) invalidates() bool {
+	return v.Kind != violationUnknown && v.Kind != violationDuplicate
+}
+
+func isValid(violations []violation) bool {
+	for _, v := range violations {
+		if v.invalidates() {
+			return false
+		}
+	}
+	return true
+}
 
+func checkValidPassport(passport *Passport, rules ruleSet, strict bool) {
+	passport.violations = rules.checkRecord(passport.fields, strict)
+	if strict {
+		for _, key := range passport.duplicates {
+			passport.violations = append(passport.violations, violation{Kind: violationDuplicate, Key: key})
+		}
+	}
+	passport.valid = isValid(passport.violations)
 }
 
-func parsePassport(passportFields []string, strict bool) Passport {
+func parsePassport(passportFields []string, rules ruleSet, strict bool) Passport {
 	var passport Passport
+	passport.fields = make(map[string]string)
 	for _, line := range passportFields {
-		fields := strings.Split(line, " ")
-		for _, field := range fields {
-			fieldParts := strings.Split(field, ":")
-			switch fieldParts[0] {
-			case "byr":
-				year, err := strconv.Atoi(fieldParts[1])
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Failed to parse int: %s\n", err)
-				}
-				passport.birthYear = year
-			case "iyr":
-				year, err := strconv.Atoi(fieldParts[1])
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Failed to parse int: %s\n", err)
-				}
-				passport.issueYear = year
-			case "eyr":
-				year, err := strconv.Atoi(fieldParts[1])
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Failed to parse int: %s\n", err)
-				}
-				passport.expireYear = year
-			case "hgt":
-				passport.height = fieldParts[1]
-			case "hcl":
-				passport.hairColor = fieldParts[1]
-			case "ecl":
-				passport.eyeColor = fieldParts[1]
-			case "pid":
-				passport.passportID = fieldParts[1]
-			case "cid":
-				country, err := strconv.Atoi(fieldParts[1])
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Failed to parse int: %s\n", err)
-				}
-				passport.countryID = country
+		for _, field := range strings.Fields(line) {
+			/* A key without a value is kept as empty, which the rules treat as missing */
+			fieldParts := strings.SplitN(field, ":", 2)
+			if len(fieldParts) == 1 {
+				fieldParts = append(fieldParts, "")
+			}
+			if _, ok := passport.fields[fieldParts[0]]; ok {
+				passport.duplicates = append(passport.duplicates, fieldParts[0])
 			}
+			passport.fields[fieldParts[0]] = fieldParts[1]
 		}
 	}
-	checkValidPassport(&passport, strict)
+	checkValidPassport(&passport, rules, strict)
 	return passport
 }
 
-func parseInputData(inputData []string, strict bool) []Passport {
+type passportRecord struct {
+	lines     []string
+	startLine int
+	endLine   int
+}
+
+func splitRecords(inputData []string) []passportRecord {
+	var records []passportRecord
 	var passportFields []string
-	var passports []Passport
 
-	for _, line := range inputData {
+	for i, line := range inputData {
 		if line != "" {
 			passportFields = append(passportFields, line)
 		} else {
-			passports = append(passports, parsePassport(passportFields, strict))
+			records = append(records, passportRecord{
+				lines:     passportFields,
+				startLine: i - len(passportFields) + 1,
+				endLine:   i,
+			})
 			passportFields = []string{}
 		}
 	}
+	/* The last record needn't be followed by a blank line */
+	if len(passportFields) > 0 {
+		records = append(records, passportRecord{
+			lines:     passportFields,
+			startLine: len(inputData) - len(passportFields) + 1,
+			endLine:   len(inputData),
+		})
+	}
+	return records
+}
+
+/* Below this many records it isn't worth starting any workers */
+const parallelThreshold = 1000
+
+/*
+ * Each worker takes a contiguous run of records and writes its passports into
+ * place, so the result is in input order however many workers there are
+ */
+func parseInputData(inputData []string, rules ruleSet, strict bool, workers int) []Passport {
+	records := splitRecords(inputData)
+	if len(records) == 0 {
+		return nil
+	}
+	passports := make([]Passport, len(records))
+	parse := func(start int, end int) {
+		for i := start; i < end; i++ {
+			passports[i] = parsePassport(records[i].lines, rules, strict)
+			passports[i].startLine = records[i].startLine
+			passports[i].endLine = records[i].endLine
+		}
+	}
+
+	if workers < 2 || len(records) < parallelThreshold {
+		parse(0, len(records))
+		return passports
+	}
+
+	var wg sync.WaitGroup
+	chunk := (len(records) + workers - 1) / workers
+	for start := 0; start < len(records); start += chunk {
+		end := start + chunk
+		if end > len(records) {
+			end = len(records)
+		}
+		wg.Add(1)
+		go func(start int, end int) {
+			defer wg.Done()
+			parse(start, end)
+		}(start, end)
+	}
+	wg.Wait()
 	return passports
 }
 
 func countValidPassports(passports []Passport) int {
 	var counter int
-	for _, passport := range passports {
+	for i, passport := range passports {
 		if passport.valid {
 			counter++
 		}
+		if tracer != nil {
+			tracer.Info("record", "record", i+1, "start_line", passport.startLine, "end_line", passport.endLine, "valid", passport.valid, "violations", passport.violations, "count", counter)
+		}
 	}
 	return counter
 }
 
+type passportReport struct {
+	Record     int         `json:"record"`
+	StartLine  int         `json:"start_line"`
+	EndLine    int         `json:"end_line"`
+	Valid      bool        `json:"valid"`
+	Violations []violation `json:"violations"`
+}
+
+func writeReport(w io.Writer, passports []Passport, format string) error {
+	encoder := json.NewEncoder(w)
+	for i, passport := range passports {
+		switch format {
+		case "text":
+			status := "valid"
+			if !passport.valid {
+				status = "invalid"
+			}
+			fmt.Fprintf(w, "record %d (lines %d-%d): %s", i+1, passport.startLine, passport.endLine, status)
+			for j, v := range passport.violations {
+				separator := ", "
+				if j == 0 {
+					separator = ": "
+				}
+				fmt.Fprintf(w, "%s%s", separator, v)
+			}
+			fmt.Fprintln(w)
+		case "json":
+			violations := passport.violations
+			if violations == nil {
+				violations = []violation{}
+			}
+			err := encoder.Encode(passportReport{
+				Record:     i + 1,
+				StartLine:  passport.startLine,
+				EndLine:    passport.endLine,
+				Valid:      passport.valid,
+				Violations: violations,
+			})
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("Unknown report format %q, expected text or json", format)
+		}
+	}
+	return nil
+}
+
+type passportExport struct {
+	Record    int               `json:"record"`
+	StartLine int               `json:"start_line"`
+	EndLine   int               `json:"end_line"`
+	Valid     bool              `json:"valid"`
+	Fields    map[string]string `json:"fields"`
+}
+
+func filterPassports(passports []Passport, filter string) ([]int, error) {
+	var indexes []int
+	for i, passport := range passports {
+		switch filter {
+		case "all":
+		case "valid":
+			if !passport.valid {
+				continue
+			}
+		case "invalid":
+			if passport.valid {
+				continue
+			}
+		default:
+			return nil, fmt.Errorf("Unknown filter %q, expected all, valid or invalid", filter)
+		}
+		indexes = append(indexes, i)
+	}
+	return indexes, nil
+}
+
+/* Columns follow the rules, then any other keys seen in the records */
+func exportColumns(passports []Passport, rules ruleSet) []string {
+	var columns []string
+	for _, rule := range rules.Fields {
+		columns = append(columns, rule.Key)
+	}
+	var extra []string
+	seen := make(map[string]bool)
+	for _, passport := range passports {
+		for key := range passport.fields {
+			if _, ok := rules.byKey[key]; !ok && !seen[key] {
+				seen[key] = true
+				extra = append(extra, key)
+			}
+		}
+	}
+	sort.Strings(extra)
+	return append(columns, extra...)
+}
+
+/* Records are numbered by their position in the input, whichever are kept */
+func exportPassports(w io.Writer, passports []Passport, rules ruleSet, format string, filter string) error {
+	indexes, err := filterPassports(passports, filter)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "jsonl":
+		encoder := json.NewEncoder(w)
+		for _, i := range indexes {
+			fields := passports[i].fields
+			if fields == nil {
+				fields = map[string]string{}
+			}
+			err := encoder.Encode(passportExport{
+				Record:    i + 1,
+				StartLine: passports[i].startLine,
+				EndLine:   passports[i].endLine,
+				Valid:     passports[i].valid,
+				Fields:    fields,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		columns := exportColumns(passports, rules)
+		writer := csv.NewWriter(w)
+		if err := writer.Write(append([]string{"record", "start_line", "end_line", "valid"}, columns...)); err != nil {
+			return err
+		}
+		for _, i := range indexes {
+			row := []string{
+				strconv.Itoa(i + 1),
+				strconv.Itoa(passports[i].startLine),
+				strconv.Itoa(passports[i].endLine),
+				strconv.FormatBool(passports[i].valid),
+			}
+			for _, column := range columns {
+				row = append(row, passports[i].fields[column])
+			}
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	return fmt.Errorf("Unknown export format %q, expected jsonl or csv", format)
+}
+
+/* Ways to make each required field's value, passing or failing part 2 */
+var passportValues = map[string]struct{ good, bad func(*rand.Rand) string }{
+	"byr": {
+		func(random *rand.Rand) string { return strconv.Itoa(1920 + random.Intn(83)) },
+		func(random *rand.Rand) string {
+			return strconv.Itoa([]int{1900, 2003}[random.Intn(2)] + random.Intn(20))
+		},
+	},
+	"iyr": {
+		func(random *rand.Rand) string { return strconv.Itoa(2010 + random.Intn(11)) },
+		func(random *rand.Rand) string {
+			return strconv.Itoa([]int{2000, 2021}[random.Intn(2)] + random.Intn(10))
+		},
+	},
+	"eyr": {
+		func(random *rand.Rand) string { return strconv.Itoa(2020 + random.Intn(11)) },
+		func(random *rand.Rand) string {
+			return strconv.Itoa([]int{2010, 2031}[random.Intn(2)] + random.Intn(10))
+		},
+	},
+	"hgt": {
+		func(random *rand.Rand) string {
+			if random.Intn(2) == 0 {
+				return fmt.Sprintf("%dcm", 150+random.Intn(44))
+			}
+			return fmt.Sprintf("%din", 59+random.Intn(18))
+		},
+		func(random *rand.Rand) string {
+			return []string{
+				fmt.Sprintf("%dcm", 100+random.Intn(50)),
+				fmt.Sprintf("%din", 77+random.Intn(20)),
+				strconv.Itoa(150 + random.Intn(44)),
+			}[random.Intn(3)]
+		},
+	},
+	"hcl": {
+		func(random *rand.Rand) string { return fmt.Sprintf("#%06x", random.Intn(1<<24)) },
+		func(random *rand.Rand) string {
+			if random.Intn(2) == 0 {
+				return fmt.Sprintf("%06x", random.Intn(1<<24))
+			}
+			return fmt.Sprintf("#%05x%c", random.Intn(1<<20), 'g'+random.Intn(20))
+		},
+	},
+	"ecl": {
+		func(random *rand.Rand) string {
+			return []string{"amb", "blu", "brn", "gry", "grn", "hzl", "oth"}[random.Intn(7)]
+		},
+		func(random *rand.Rand) string { return []string{"red", "xry", "blue", "zzz"}[random.Intn(4)] },
+	},
+	"pid": {
+		func(random *rand.Rand) string { return fmt.Sprintf("%09d", random.Intn(1000000000)) },
+		func(random *rand.Rand) string {
+			if random.Intn(2) == 0 {
+				return fmt.Sprintf("%08d", random.Intn(100000000))
+			}
+			return fmt.Sprintf("%010d", random.Intn(1000000000))
+		},
+	},
+}
+
+/*
+ * Each passport is either valid, missing a required field, or has every field
+ * but one bad value, which only part 2 rejects. The answers count the kinds
+ * chosen rather than checking the generated fields against the rules.
+ */
+func generatePassports(random *rand.Rand, size int) ([]string, int, int) {
+	required := []string{"byr", "iyr", "eyr", "hgt", "hcl", "ecl", "pid"}
+	var lines []string
+	var present, valid int
+
+	for i := 0; i < size; i++ {
+		missing, bad := "", ""
+		switch random.Intn(3) {
+		case 0:
+			valid++
+			present++
+		case 1:
+			missing = required[random.Intn(len(required))]
+		case 2:
+			bad = required[random.Intn(len(required))]
+			present++
+		}
+
+		var fields []string
+		for _, key := range required {
+			switch key {
+			case missing:
+			case bad:
+				fields = append(fields, key+":"+passportValues[key].bad(random))
+			default:
+				fields = append(fields, key+":"+passportValues[key].good(random))
+			}
+		}
+		if random.Intn(2) == 0 {
+			fields = append(fields, fmt.Sprintf("cid:%d", 1+random.Intn(350)))
+		}
+		random.Shuffle(len(fields), func(i, j int) {
+			fields[i], fields[j] = fields[j], fields[i]
+		})
+
+		/* Spread the fields over a few lines, as the real input does */
+		if i > 0 {
+			lines = append(lines, "")
+		}
+		for len(fields) > 0 {
+			take := 1 + random.Intn(len(fields))
+			lines = append(lines, strings.Join(fields[:take], " "))
+			fields = fields[take:]
+		}
+	}
+	return lines, present, valid
+}
+
+func writeGenerated(prefix string, lines []string, part1 int, part2 int) error {
+	if err := ioutil.WriteFile(prefix, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(prefix+".answers", []byte(fmt.Sprintf("%d\n%d\n", part1, part2)), 0644)
+}
+
 func main() {
 	var fileName string
+	var rulesFile string
+	var report string
+	var export string
+	var filter string
+	var workers int
+	var generate string
+	var seed int64
+	var size int
 	var part2 bool
+	var verbose bool
+	var veryVerbose bool
+	var traceFile string
 	var result int
 	var passports []Passport
 
 	flag.StringVar(&fileName, "f", "input", "Input file")
+	flag.StringVar(&rulesFile, "rules", "", "JSON rules file, defaults to the passport rules")
+	flag.StringVar(&report, "report", "", "Print each record's violations as text or json instead of the count")
+	flag.StringVar(&export, "export", "", "Write the parsed records as jsonl or csv instead of the count")
+	flag.StringVar(&filter, "filter", "all", "Records to export: all, valid or invalid (strict with -2)")
+	flag.IntVar(&workers, "workers", runtime.NumCPU(), "Validate large batches across this many workers")
+	flag.StringVar(&generate, "generate", "", "Write a random input to this file and its answers to the file plus .answers")
+	flag.Int64Var(&seed, "seed", 1, "Seed for -generate")
+	flag.IntVar(&size, "size", 290, "Number of passports for -generate")
+	flag.BoolVar(&verbose, "v", false, "Log progress to STDERR")
+	flag.BoolVar(&veryVerbose, "vv", false, "Log progress and details to STDERR")
+	flag.StringVar(&traceFile, "trace", "", "Write each record counted to this file as JSON lines")
 	flag.BoolVar(&part2, "2", false, "Compute part 2 of the exercise")
 	flag.Parse()
 
+	flush, err := setupLogging(verbose, veryVerbose, traceFile)
+	if err != nil {
+		die(err)
+	}
+	closeTrace = flush
+	defer closeTrace()
+
+	if generate != "" {
+		lines, part1Answer, part2Answer := generatePassports(rand.New(rand.NewSource(seed)), size)
+		if err := writeGenerated(generate, lines, part1Answer, part2Answer); err != nil {
+			die(err)
+		}
+		return
+	}
+
+	rules, err := readRules(rulesFile)
+	if err != nil {
+		die(err)
+	}
+	logger.Debug("Loaded rules", "file", rulesFile, "fields", len(rules.Fields))
+
 	inputData, err := readFile(fileName)
 	if err != nil {
 		die(err)
 	}
 
-	passports = parseInputData(inputData, part2)
+	passports = parseInputData(inputData, rules, part2, workers)
+	logger.Info("Read passports", "file", fileName, "records", len(passports), "strict", part2)
+	if report != "" {
+		if err := writeReport(os.Stdout, passports, report); err != nil {
+			die(err)
+		}
+		return
+	}
+	if export != "" {
+		if err := exportPassports(os.Stdout, passports, rules, export, filter); err != nil {
+			die(err)
+		}
+		return
+	}
 	result = countValidPassports(passports)
 
 	fmt.Println(result)
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"io/ioutil"
+	"log/slog"
+	"math/rand"
 	"os"
 	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 )
@@ -121,70 +125,83 @@ func TestReadFileSingleNewline(t *testing.T) {
 	}
 }
 
+func defaultRules(t *testing.T) ruleSet {
+	rules, err := loadRules([]byte(defaultRulesJSON))
+	if err != nil {
+		t.Log("Failed to load default rules:", err)
+		t.FailNow()
+	}
+	return rules
+}
+
 func TestCheckValidPassport(t *testing.T) {
+	rules := defaultRules(t)
 	passport := Passport{
-		birthYear:  2000,
-		issueYear:  2000,
-		expireYear: 2000,
-		height:     "200cm",
-		hairColor:  "#fffff",
-		eyeColor:   "grn",
-		passportID: "019123",
-		countryID:  0,
-		valid:      false,
-	}
-	checkValidPassport(&passport, false)
+		fields: map[string]string{
+			"byr": "2000",
+			"iyr": "2000",
+			"eyr": "2000",
+			"hgt": "200cm",
+			"hcl": "#fffff",
+			"ecl": "grn",
+			"pid": "019123",
+		},
+		valid: false,
+	}
+	checkValidPassport(&passport, rules, false)
 	if passport.valid != true {
 		t.Log("Valid passport marked invalid:", passport)
 		t.Fail()
 	}
 
 	passport = Passport{
-		birthYear:  2000,
-		issueYear:  2010,
-		expireYear: 2020,
-		height:     "182cm",
-		hairColor:  "#123456",
-		eyeColor:   "grn",
-		passportID: "012345678",
-		countryID:  0,
-		valid:      false,
-	}
-	checkValidPassport(&passport, true)
+		fields: map[string]string{
+			"byr": "2000",
+			"iyr": "2010",
+			"eyr": "2020",
+			"hgt": "182cm",
+			"hcl": "#123456",
+			"ecl": "grn",
+			"pid": "012345678",
+		},
+		valid: false,
+	}
+	checkValidPassport(&passport, rules, true)
 	if passport.valid != true {
 		t.Log("Valid passport marked invalid with strict conditions:", passport)
 		t.Fail()
 	}
 
 	passport = Passport{
-		birthYear:  2000,
-		issueYear:  2000,
-		expireYear: 0,
-		height:     "200cm",
-		hairColor:  "#fffff",
-		eyeColor:   "grn",
-		passportID: "019123",
-		countryID:  0,
-		valid:      false,
-	}
-	checkValidPassport(&passport, false)
+		fields: map[string]string{
+			"byr": "2000",
+			"iyr": "2000",
+			"hgt": "200cm",
+			"hcl": "#fffff",
+			"ecl": "grn",
+			"pid": "019123",
+		},
+		valid: false,
+	}
+	checkValidPassport(&passport, rules, false)
 	if passport.valid == true {
 		t.Log("Invalid passport marked valid:", passport)
 		t.Fail()
 	}
 
 	passport = Passport{
-		birthYear:  1000,
-		issueYear:  2010,
-		expireYear: 2020,
-		height:     "182cm",
-		hairColor:  "#123456",
-		eyeColor:   "grn",
-		passportID: "012345678",
-		countryID:  0,
-		valid:      false,
-	}
-	checkValidPassport(&passport, true)
+		fields: map[string]string{
+			"byr": "1000",
+			"iyr": "2010",
+			"eyr": "2020",
+			"hgt": "182cm",
+			"hcl": "#123456",
+			"ecl": "grn",
+			"pid": "012345678",
+		},
+		valid: false,
+	}
+	checkValidPassport(&passport, rules, true)
 	if passport.valid == true {
 		t.Log("Invalid passport marked valid with strict conditions:", passport)
 		t.Fail()
@@ -195,26 +212,12 @@ func TestCheckValidPassport(t *testing.T) {
 func TestCountValidPassports(t *testing.T) {
 	passports := []Passport{
 		Passport{
-			birthYear:  2000,
-			issueYear:  2000,
-			expireYear: 0,
-			height:     "200cm",
-			hairColor:  "#fffff",
-			eyeColor:   "grn",
-			passportID: "019123",
-			countryID:  0,
-			valid:      false,
+			fields: map[string]string{"byr": "2000", "iyr": "2000", "hgt": "200cm"},
+			valid:  false,
 		},
 		Passport{
-			birthYear:  2000,
-			issueYear:  2000,
-			expireYear: 2020,
-			height:     "200cm",
-			hairColor:  "#fffff",
-			eyeColor:   "grn",
-			passportID: "019123",
-			countryID:  0,
-			valid:      true,
+			fields: map[string]string{"byr": "2000", "iyr": "2000", "eyr": "2020", "hgt": "200cm"},
+			valid:  true,
 		},
 	}
 	count := countValidPassports(passports)
@@ -226,21 +229,23 @@ func TestCountValidPassports(t *testing.T) {
 
 func TestParsePassport(t *testing.T) {
 	passport := Passport{
-		birthYear:  1937,
-		issueYear:  2017,
-		expireYear: 2020,
-		height:     "183cm",
-		hairColor:  "#fffffd",
-		eyeColor:   "gry",
-		passportID: "860033327",
-		countryID:  147,
-		valid:      true,
+		fields: map[string]string{
+			"ecl": "gry",
+			"pid": "860033327",
+			"eyr": "2020",
+			"hcl": "#fffffd",
+			"byr": "1937",
+			"iyr": "2017",
+			"cid": "147",
+			"hgt": "183cm",
+		},
+		valid: true,
 	}
 	passportFields := []string{
 		"ecl:gry pid:860033327 eyr:2020 hcl:#fffffd",
 		"byr:1937 iyr:2017 cid:147 hgt:183cm",
 	}
-	result := parsePassport(passportFields, false)
+	result := parsePassport(passportFields, defaultRules(t), false)
 	if !reflect.DeepEqual(passport, result) {
 		t.Log("Error, expected and actual passport differ", passport, result)
 		t.Fail()
@@ -248,186 +253,584 @@ func TestParsePassport(t *testing.T) {
 }
 
 func TestValidBirthYear(t *testing.T) {
-	if validBirthYear(1919) {
+	rules := defaultRules(t)
+	if rules.byKey["byr"].checkValue("1919") == "" {
 		t.Log("Error, 1919 considered a valid birth year")
 		t.Fail()
 	}
-	if !validBirthYear(1920) {
+	if rules.byKey["byr"].checkValue("1920") != "" {
 		t.Log("Error, 1920 not considered a valid birth year")
 		t.Fail()
 	}
-	if !validBirthYear(2002) {
+	if rules.byKey["byr"].checkValue("2002") != "" {
 		t.Log("Error, 2002 not considered a valid birth year")
 		t.Fail()
 	}
-	if validBirthYear(2003) {
+	if rules.byKey["byr"].checkValue("2003") == "" {
 		t.Log("Error, 2003 considered a valid birth year")
 		t.Fail()
 	}
 }
 
 func TestValidIssueYear(t *testing.T) {
-	if validIssueYear(2009) {
+	rules := defaultRules(t)
+	if rules.byKey["iyr"].checkValue("2009") == "" {
 		t.Log("Error, 2009 considered a valid issue year")
 		t.Fail()
 	}
-	if !validIssueYear(2010) {
+	if rules.byKey["iyr"].checkValue("2010") != "" {
 		t.Log("Error, 2010 not considered a valid issue year")
 		t.Fail()
 	}
-	if !validIssueYear(2020) {
+	if rules.byKey["iyr"].checkValue("2020") != "" {
 		t.Log("Error, 2020 not considered a valid issue year")
 		t.Fail()
 	}
-	if validIssueYear(2021) {
+	if rules.byKey["iyr"].checkValue("2021") == "" {
 		t.Log("Error, 2021 considered a valid issue year")
 		t.Fail()
 	}
 }
 
 func TestValidExpirationYear(t *testing.T) {
-	if validExpirationYear(2019) {
+	rules := defaultRules(t)
+	if rules.byKey["eyr"].checkValue("2019") == "" {
 		t.Log("Error, 2019 considered a valid expiration year")
 		t.Fail()
 	}
-	if !validExpirationYear(2020) {
+	if rules.byKey["eyr"].checkValue("2020") != "" {
 		t.Log("Error, 2020 not considered a valid expiration year")
 		t.Fail()
 	}
-	if !validExpirationYear(2030) {
+	if rules.byKey["eyr"].checkValue("2030") != "" {
 		t.Log("Error, 2030 not considered a valid expiration year")
 		t.Fail()
 	}
-	if validExpirationYear(2031) {
+	if rules.byKey["eyr"].checkValue("2031") == "" {
 		t.Log("Error, 2031 considered a valid expiration year")
 		t.Fail()
 	}
 }
 
 func TestValidHeight(t *testing.T) {
+	rules := defaultRules(t)
 	// Metric
-	if validHeight("149cm") {
+	if rules.byKey["hgt"].checkValue("149cm") == "" {
 		t.Log("Error, 149cm considered a valid height")
 		t.Fail()
 	}
-	if !validHeight("150cm") {
+	if rules.byKey["hgt"].checkValue("150cm") != "" {
 		t.Log("Error, 150cm not considered a valid height")
 		t.Fail()
 	}
-	if !validHeight("193cm") {
+	if rules.byKey["hgt"].checkValue("193cm") != "" {
 		t.Log("Error, 193cm not considered a valid height")
 		t.Fail()
 	}
-	if validHeight("194cm") {
+	if rules.byKey["hgt"].checkValue("194cm") == "" {
 		t.Log("Error, 194cm considered a valid height")
 		t.Fail()
 	}
 
 	// When will we stop wasting our lives catering to this?
-	if validHeight("58in") {
+	if rules.byKey["hgt"].checkValue("58in") == "" {
 		t.Log("Error, 58in considered a valid height")
 		t.Fail()
 	}
-	if !validHeight("59in") {
+	if rules.byKey["hgt"].checkValue("59in") != "" {
 		t.Log("Error, 59in not considered a valid height")
 		t.Fail()
 	}
-	if !validHeight("76in") {
+	if rules.byKey["hgt"].checkValue("76in") != "" {
 		t.Log("Error, 76in not considered a valid height")
 		t.Fail()
 	}
-	if validHeight("77in") {
+	if rules.byKey["hgt"].checkValue("77in") == "" {
 		t.Log("Error, 77in considered a valid height")
 		t.Fail()
 	}
 
 	// Random string
-	if validHeight("invalid") {
+	if rules.byKey["hgt"].checkValue("invalid") == "" {
 		t.Log("Error, invalid considered a valid height")
 	}
 
 	// Missing digits
-	if validHeight("cm") {
+	if rules.byKey["hgt"].checkValue("cm") == "" {
 		t.Log("Error, cm considered a valid height")
 	}
 
 	// Invalid unit
-	if validHeight("180xx") {
+	if rules.byKey["hgt"].checkValue("180xx") == "" {
 		t.Log("Error, 180xx considered a valid height")
 	}
 
 	// Wrong order
-	if validHeight("cm150") {
+	if rules.byKey["hgt"].checkValue("cm150") == "" {
 		t.Log("Error, cm150 considered a valid height")
 	}
 
 	// Too short
-	if validHeight("x") {
+	if rules.byKey["hgt"].checkValue("x") == "" {
 		t.Log("Error, x considered a valid height")
 	}
 
 }
 
 func TestValidHairColor(t *testing.T) {
-	if !validHairColor("#0099af") {
+	rules := defaultRules(t)
+	if rules.byKey["hcl"].checkValue("#0099af") != "" {
 		t.Log("Error, #0099af not considered a valid hair color")
 		t.Fail()
 	}
 	// too long
-	if validHairColor("#0099afa") {
+	if rules.byKey["hcl"].checkValue("#0099afa") == "" {
 		t.Log("Error, #0099afa considered a valid hair color")
 		t.Fail()
 	}
 	// too short
-	if validHairColor("#0099a") {
+	if rules.byKey["hcl"].checkValue("#0099a") == "" {
 		t.Log("Error, #0099a considered a valid hair color")
 		t.Fail()
 	}
 	// Hex out of bounds
-	if validHairColor("#0099ag") {
+	if rules.byKey["hcl"].checkValue("#0099ag") == "" {
 		t.Log("Error, #0099ag considered a valid hair color")
 		t.Fail()
 	}
 	// Missing #
-	if validHairColor("0099af") {
+	if rules.byKey["hcl"].checkValue("0099af") == "" {
 		t.Log("Error, 0099af considered a valid hair color")
 		t.Fail()
 	}
 }
 
 func TestValidEyeColor(t *testing.T) {
-	if !validEyeColor("grn") {
+	rules := defaultRules(t)
+	if rules.byKey["ecl"].checkValue("grn") != "" {
 		t.Log("Error, grn not considered a valid eye color")
 		t.Fail()
 	}
-	if validEyeColor("green") {
+	if rules.byKey["ecl"].checkValue("green") == "" {
 		t.Log("Error, green considered a valid eye color")
 		t.Fail()
 	}
-	if validEyeColor("grns") {
+	if rules.byKey["ecl"].checkValue("grns") == "" {
 		t.Log("Error, grns considered a valid eye color")
 		t.Fail()
 	}
 }
 
 func TestValidPassportNumber(t *testing.T) {
-	if !validPassportNumber("000000001") {
+	rules := defaultRules(t)
+	if rules.byKey["pid"].checkValue("000000001") != "" {
 		t.Log("Error, 000000001 not considered a valid passport number")
 		t.Fail()
 	}
 	// Too short
-	if validPassportNumber("12345678") {
+	if rules.byKey["pid"].checkValue("12345678") == "" {
 		t.Log("Error, 12345678 considered a valid passport number")
 		t.Fail()
 	}
 	// Too long
-	if validPassportNumber("1234567890") {
+	if rules.byKey["pid"].checkValue("1234567890") == "" {
 		t.Log("Error, 1234567890 considered a valid passport number")
 		t.Fail()
 	}
 	// Not a number
-	if validPassportNumber("a1234567890") {
+	if rules.byKey["pid"].checkValue("a1234567890") == "" {
 		t.Log("Error, a1234567890 considered a valid passport number")
 		t.Fail()
 	}
 }
+
+func TestLoadRules(t *testing.T) {
+	rules, err := loadRules([]byte(`{"fields": [
+		{"key": "name", "required": true},
+		{"key": "age", "type": "int", "min": 0, "max": 150},
+		{"key": "size", "type": "unit", "units": {"m": {"min": 1, "max": 2}, "cm": {"min": 100, "max": 200}}}
+	]}`))
+	if err != nil {
+		t.Log("Unexpected error:", err)
+		t.FailNow()
+	}
+
+	if !isValid(rules.checkRecord(map[string]string{"name": "x", "other": "y"}, false)) {
+		t.Log("Error, record with only the required field marked invalid")
+		t.Fail()
+	}
+	if !isValid(rules.checkRecord(map[string]string{"name": "x", "other": "y"}, true)) {
+		t.Log("Error, record with an unknown field marked invalid with strict conditions")
+		t.Fail()
+	}
+	if isValid(rules.checkRecord(map[string]string{"age": "10"}, false)) {
+		t.Log("Error, record missing a required field marked valid")
+		t.Fail()
+	}
+	if isValid(rules.checkRecord(map[string]string{"name": "x", "age": "200"}, true)) {
+		t.Log("Error, out of range optional field marked valid with strict conditions")
+		t.Fail()
+	}
+	if !isValid(rules.checkRecord(map[string]string{"name": "x", "age": "200"}, false)) {
+		t.Log("Error, out of range optional field marked invalid without strict conditions")
+		t.Fail()
+	}
+	if rules.byKey["size"].checkValue("150cm") != "" {
+		t.Log("Error, 150cm not considered a valid size")
+		t.Fail()
+	}
+	if rules.byKey["size"].checkValue("3m") == "" {
+		t.Log("Error, 3m considered a valid size")
+		t.Fail()
+	}
+
+	for _, bad := range []string{
+		`not json`,
+		`{"fields": [{"required": true}]}`,
+		`{"fields": [{"key": "a"}, {"key": "a"}]}`,
+		`{"fields": [{"key": "a", "type": "float"}]}`,
+		`{"fields": [{"key": "a", "type": "regex", "pattern": "("}]}`,
+		`{"fields": [{"key": "a", "type": "unit"}]}`,
+	} {
+		if _, err := loadRules([]byte(bad)); err == nil {
+			t.Log("Expected error loading rules", bad)
+			t.Fail()
+		}
+	}
+}
+
+func TestCheckRecord(t *testing.T) {
+	rules := defaultRules(t)
+	record := map[string]string{
+		"byr": "1900",
+		"iyr": "twenty",
+		"eyr": "2025",
+		"hgt": "170",
+		"hcl": "#123abc",
+		"ecl": "red",
+		"xyz": "1",
+	}
+	expected := []violation{
+		{Kind: violationRange, Key: "byr", Value: "1900"},
+		{Kind: violationFormat, Key: "iyr", Value: "twenty"},
+		{Kind: violationFormat, Key: "hgt", Value: "170"},
+		{Kind: violationFormat, Key: "ecl", Value: "red"},
+		{Kind: violationMissing, Key: "pid"},
+		{Kind: violationUnknown, Key: "xyz", Value: "1"},
+	}
+	violations := rules.checkRecord(record, true)
+	if !reflect.DeepEqual(expected, violations) {
+		t.Log("Error, unexpected violations", violations)
+		t.Fail()
+	}
+
+	expected = []violation{
+		{Kind: violationMissing, Key: "pid"},
+	}
+	violations = rules.checkRecord(record, false)
+	if !reflect.DeepEqual(expected, violations) {
+		t.Log("Error, unexpected violations without strict conditions", violations)
+		t.Fail()
+	}
+}
+
+func TestParsePassportDuplicate(t *testing.T) {
+	passportFields := []string{
+		"ecl:gry pid:860033327 eyr:2020 hcl:#fffffd",
+		"byr:1937 iyr:2017 hgt:183cm ecl:blu",
+	}
+	passport := parsePassport(passportFields, defaultRules(t), true)
+	if !passport.valid {
+		t.Log("Error, passport with a duplicate key marked invalid")
+		t.Fail()
+	}
+	expected := []violation{{Kind: violationDuplicate, Key: "ecl"}}
+	if !reflect.DeepEqual(expected, passport.violations) {
+		t.Log("Error, unexpected violations", passport.violations)
+		t.Fail()
+	}
+	if passport.fields["ecl"] != "blu" {
+		t.Log("Error, expected the last ecl value, got", passport.fields["ecl"])
+		t.Fail()
+	}
+}
+
+func TestParsePassportUnknownKey(t *testing.T) {
+	passportFields := []string{
+		"ecl:gry pid:860033327 eyr:2020 hcl:#fffffd",
+		"byr:1937 iyr:2017 cid:147 hgt:183cm foo:bar",
+	}
+	passport := parsePassport(passportFields, defaultRules(t), true)
+	if !passport.valid {
+		t.Log("Error, passport with an extra key marked invalid")
+		t.Fail()
+	}
+	var out bytes.Buffer
+	writeReport(&out, []Passport{passport}, "text")
+	if !strings.Contains(out.String(), "valid: unknown foo:bar") {
+		t.Log("Error, expected the extra key in the report, got", out.String())
+		t.Fail()
+	}
+}
+
+func TestParseInputDataLineSpans(t *testing.T) {
+	passports := parseInputData([]string{
+		"ecl:gry pid:860033327 eyr:2020 hcl:#fffffd",
+		"byr:1937 iyr:2017 cid:147 hgt:183cm",
+		"",
+		"iyr:2013 ecl:amb cid:350 eyr:2023 pid:028048884",
+		"",
+	}, defaultRules(t), false, 1)
+	if len(passports) != 2 {
+		t.Log("Expected 2 passports, got", len(passports))
+		t.FailNow()
+	}
+	if passports[0].startLine != 1 || passports[0].endLine != 2 {
+		t.Log("Error, expected lines 1-2, got", passports[0].startLine, passports[0].endLine)
+		t.Fail()
+	}
+	if passports[1].startLine != 4 || passports[1].endLine != 4 {
+		t.Log("Error, expected lines 4-4, got", passports[1].startLine, passports[1].endLine)
+		t.Fail()
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	passports := []Passport{
+		{startLine: 1, endLine: 2, valid: true},
+		{
+			startLine: 4,
+			endLine:   4,
+			violations: []violation{
+				{Kind: violationMissing, Key: "hgt"},
+				{Kind: violationRange, Key: "byr", Value: "1900"},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := writeReport(&buf, passports, "text"); err != nil {
+		t.Log("Unexpected error:", err)
+		t.Fail()
+	}
+	expected := "record 1 (lines 1-2): valid\n" +
+		"record 2 (lines 4-4): invalid: missing hgt, range byr:1900\n"
+	if buf.String() != expected {
+		t.Log("Error, unexpected text report", buf.String())
+		t.Fail()
+	}
+
+	buf.Reset()
+	if err := writeReport(&buf, passports, "json"); err != nil {
+		t.Log("Unexpected error:", err)
+		t.Fail()
+	}
+	expected = `{"record":1,"start_line":1,"end_line":2,"valid":true,"violations":[]}` + "\n" +
+		`{"record":2,"start_line":4,"end_line":4,"valid":false,"violations":[` +
+		`{"kind":"missing","key":"hgt"},{"kind":"range","key":"byr","value":"1900"}]}` + "\n"
+	if buf.String() != expected {
+		t.Log("Error, unexpected JSON report", buf.String())
+		t.Fail()
+	}
+
+	if err := writeReport(&buf, passports, "xml"); err == nil {
+		t.Log("Expected error for unknown report format")
+		t.Fail()
+	}
+}
+
+func TestExportPassports(t *testing.T) {
+	rules := defaultRules(t)
+	passports := parseInputData([]string{
+		"ecl:gry pid:860033327 eyr:2020 hcl:#fffffd",
+		"byr:1937 iyr:2017 cid:147 hgt:183cm",
+		"",
+		"iyr:2013 ecl:amb cid:350 eyr:2023 pid:028048884 zzz:1",
+		"",
+	}, rules, false, 1)
+
+	var buf bytes.Buffer
+	if err := exportPassports(&buf, passports, rules, "csv", "all"); err != nil {
+		t.Log("Unexpected error:", err)
+		t.Fail()
+	}
+	expected := "record,start_line,end_line,valid,byr,iyr,eyr,hgt,hcl,ecl,pid,cid,zzz\n" +
+		"1,1,2,true,1937,2017,2020,183cm,#fffffd,gry,860033327,147,\n" +
+		"2,4,4,false,,2013,2023,,,amb,028048884,350,1\n"
+	if buf.String() != expected {
+		t.Log("Error, unexpected CSV export", buf.String())
+		t.Fail()
+	}
+
+	buf.Reset()
+	if err := exportPassports(&buf, passports, rules, "jsonl", "invalid"); err != nil {
+		t.Log("Unexpected error:", err)
+		t.Fail()
+	}
+	expected = `{"record":2,"start_line":4,"end_line":4,"valid":false,"fields":` +
+		`{"cid":"350","ecl":"amb","eyr":"2023","iyr":"2013","pid":"028048884","zzz":"1"}}` + "\n"
+	if buf.String() != expected {
+		t.Log("Error, unexpected JSON Lines export", buf.String())
+		t.Fail()
+	}
+
+	buf.Reset()
+	if err := exportPassports(&buf, passports, rules, "jsonl", "valid"); err != nil {
+		t.Log("Unexpected error:", err)
+		t.Fail()
+	}
+	if strings.Count(buf.String(), "\n") != 1 || !strings.HasPrefix(buf.String(), `{"record":1,`) {
+		t.Log("Error, expected only the first record, got", buf.String())
+		t.Fail()
+	}
+
+	if err := exportPassports(&buf, passports, rules, "xml", "all"); err == nil {
+		t.Log("Expected error for unknown export format")
+		t.Fail()
+	}
+	if err := exportPassports(&buf, passports, rules, "csv", "some"); err == nil {
+		t.Log("Expected error for unknown filter")
+		t.Fail()
+	}
+}
+
+func TestParseInputDataWorkers(t *testing.T) {
+	rules := defaultRules(t)
+	lines, _, _ := generatePassports(rand.New(rand.NewSource(1)), 5000)
+
+	serial := parseInputData(lines, rules, true, 1)
+	parallel := parseInputData(lines, rules, true, 4)
+	if len(serial) != 5000 {
+		t.Log("Expected 5000 passports, got", len(serial))
+		t.Fail()
+	}
+	if !reflect.DeepEqual(serial, parallel) {
+		t.Log("Error, serial and parallel validation differ")
+		t.Fail()
+	}
+	if valid := countValidPassports(serial); valid == 0 || valid == len(serial) {
+		t.Log("Expected a mix of valid and invalid passports, got", valid, "valid")
+		t.Fail()
+	}
+}
+
+var benchmarkPassportLines []string
+
+func millionPassportLines() []string {
+	if benchmarkPassportLines == nil {
+		benchmarkPassportLines, _, _ = generatePassports(rand.New(rand.NewSource(1)), 1000000)
+	}
+	return benchmarkPassportLines
+}
+
+func BenchmarkParseInputDataSerial(b *testing.B) {
+	lines := millionPassportLines()
+	rules, err := loadRules([]byte(defaultRulesJSON))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parseInputData(lines, rules, true, 1)
+	}
+}
+
+func BenchmarkParseInputDataParallel(b *testing.B) {
+	lines := millionPassportLines()
+	rules, err := loadRules([]byte(defaultRulesJSON))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parseInputData(lines, rules, true, runtime.NumCPU())
+	}
+}
+
+func TestGeneratePassports(t *testing.T) {
+	rules := defaultRules(t)
+	for seed := int64(1); seed <= 5; seed++ {
+		lines, part1, part2 := generatePassports(rand.New(rand.NewSource(seed)), 300)
+		if valid := countValidPassports(parseInputData(lines, rules, false, 1)); valid != part1 {
+			t.Log("Seed", seed, "expected", part1, "passports with every field, got", valid)
+			t.Fail()
+		}
+		if valid := countValidPassports(parseInputData(lines, rules, true, 1)); valid != part2 {
+			t.Log("Seed", seed, "expected", part2, "valid passports, got", valid)
+			t.Fail()
+		}
+	}
+}
+
+func TestSplitRecordsLastRecord(t *testing.T) {
+	records := splitRecords([]string{"byr:1937", "", "iyr:2017", "hgt:183cm"})
+	expected := []passportRecord{
+		{lines: []string{"byr:1937"}, startLine: 1, endLine: 1},
+		{lines: []string{"iyr:2017", "hgt:183cm"}, startLine: 3, endLine: 4},
+	}
+	if !reflect.DeepEqual(expected, records) {
+		t.Log("Error, unexpected records", records)
+		t.Fail()
+	}
+}
+
+func TestParsePassportMalformedFields(t *testing.T) {
+	passport := parsePassport([]string{"ecl  pid:86:0033327 :x", "hgt:"}, defaultRules(t), false)
+	expected := map[string]string{"ecl": "", "pid": "86:0033327", "": "x", "hgt": ""}
+	if !reflect.DeepEqual(expected, passport.fields) {
+		t.Log("Error, unexpected fields", passport.fields)
+		t.Fail()
+	}
+}
+
+func FuzzParseInputData(f *testing.F) {
+	f.Add("ecl:gry pid:860033327 eyr:2020 hcl:#fffffd\nbyr:1937 iyr:2017 cid:147 hgt:183cm\n\n" +
+		"iyr:2013 ecl:amb cid:350 eyr:2023 pid:028048884\nhcl:#cfa07d byr:1929\n")
+	f.Add("hcl:#ae17e1 iyr:2013\neyr:2024\necl:brn pid:760753108 byr:1931\nhgt:179cm")
+	f.Add("ecl\n\n\n:\nhgt:190 hgt:59in")
+	rules, err := loadRules([]byte(defaultRulesJSON))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		lines := strings.Split(input, "\n")
+		var fieldLines int
+		for _, line := range lines {
+			if line != "" {
+				fieldLines++
+			}
+		}
+		var recordLines int
+		for _, record := range splitRecords(lines) {
+			recordLines += len(record.lines)
+		}
+		if recordLines != fieldLines {
+			t.Log("Error, records hold", recordLines, "of", fieldLines, "lines")
+			t.Fail()
+		}
+
+		present := parseInputData(lines, rules, false, 1)
+		valid := parseInputData(lines, rules, true, 1)
+		for i := range valid {
+			if valid[i].valid && !present[i].valid {
+				t.Log("Error, passport", i+1, "only valid when strict", valid[i].fields)
+				t.Fail()
+			}
+		}
+	})
+}
+
+func TestParseFailuresLogged(t *testing.T) {
+	var logged bytes.Buffer
+	logger = slog.New(slog.NewTextHandler(&logged, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	defer func() { logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})) }()
+
+	rules, _ := loadRules([]byte(defaultRulesJSON))
+	parsePassport([]string{"byr:19x0 hgt:6ft"}, rules, true)
+	for _, message := range []string{`msg="Failed to parse int" key=byr`, `msg="No known unit" key=hgt value=6ft`} {
+		if !strings.Contains(logged.String(), message) {
+			t.Log("Error, expected", message, "in", logged.String())
+			t.Fail()
+		}
+	}
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bufio"
 	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
+	"math/rand"
 	"os"
 	"sort"
 	"strconv"
@@ -14,9 +17,44 @@ import (
 /* Errors should be printed to STDERR not STDOUT */
 func die(err error) {
 	fmt.Fprintf(os.Stderr, "%s\n", err)
+	closeTrace()
 	os.Exit(1)
 }
 
+var logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+var tracer *slog.Logger
+
+var closeTrace = func() {}
+
+func setupLogging(verbose bool, veryVerbose bool, traceFile string) (func(), error) {
+	level := slog.LevelWarn
+	if verbose {
+		level = slog.LevelInfo
+	}
+	if veryVerbose {
+		level = slog.LevelDebug
+	}
+	logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	if traceFile == "" {
+		return func() {}, nil
+	}
+	file, err := os.Create(traceFile)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewWriter(file)
+	tracer = slog.New(slog.NewJSONHandler(buffered, nil))
+	return func() {
+		if err := buffered.Flush(); err != nil {
+			logger.Warn("Failed to write the trace", "file", traceFile, "err", err)
+		}
+		if err := file.Close(); err != nil {
+			logger.Warn("Failed to close the trace", "file", traceFile, "err", err)
+		}
+	}, nil
+}
+
 func readFile(filename string) ([]string, error) {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
@@ -31,82 +69,231 @@ func readFile(filename string) ([]string, error) {
 	return lines, nil
 }
 
-func findSeatRow(seat string) int {
-	var seatNumber []byte
-	if len(seat) != 7 {
-		fmt.Fprintf(os.Stderr, "Bad seat ID: %s, length %d instead of 7\n", seat, len(seat))
-		return 0
+/*
+ * Each axis of a plane is a binary space partition: one letter picks the lower
+ * half, the other the upper half, and each letter halves what is left. A
+ * boarding pass is the code for every axis in turn.
+ */
+type axis struct {
+	lower byte
+	upper byte
+	bits  int
+}
+
+type layout struct {
+	axes []axis
+}
+
+/* Seat IDs index a slice of every seat, so a plane can't be too big to allocate */
+const maxSeats = 1 << 24
+
+/* 128 rows of 8 seats */
+var defaultLayout = layout{axes: []axis{{'F', 'B', 7}, {'L', 'R', 3}}}
+
+func (a axis) size() int {
+	return 1 << a.bits
+}
+
+func (a axis) decode(code string) (int, error) {
+	if len(code) != a.bits {
+		return 0, fmt.Errorf("Bad seat ID: %s, length %d instead of %d", code, len(code), a.bits)
 	}
-	for _, c := range seat {
-		switch c {
-		case 'F':
-			seatNumber = append(seatNumber, '0')
-		case 'B':
-			seatNumber = append(seatNumber, '1')
+	var position int
+	for i := 0; i < len(code); i++ {
+		position <<= 1
+		switch code[i] {
+		case a.lower:
+		case a.upper:
+			position |= 1
 		default:
-			fmt.Fprintf(os.Stderr, "Error, bad position identifier in %s: %c\n", seat, c)
-			return 0
+			return 0, fmt.Errorf("Error, bad position identifier in %s: %c", code, code[i])
 		}
 	}
-	if row, err := strconv.ParseUint(string(seatNumber), 2, 8); err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err)
-		return 0
-	} else {
-		return int(row)
+	return position, nil
+}
+
+/* Parses axes written as letter pairs and sizes, e.g. "FB:128,LR:8" */
+func parseLayout(spec string) (layout, error) {
+	var l layout
+	seen := make(map[byte]bool)
+	for _, field := range strings.Split(spec, ",") {
+		parts := strings.Split(strings.TrimSpace(field), ":")
+		if len(parts) != 2 || len(parts[0]) != 2 || parts[0][0] == parts[0][1] {
+			return l, fmt.Errorf("Bad axis %q, expected two different letters and a size, e.g. FB:128", field)
+		}
+		size, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return l, err
+		}
+		if size < 2 || size&(size-1) != 0 {
+			return l, fmt.Errorf("Bad axis %q, size must be a power of two", field)
+		}
+		for _, letter := range []byte(parts[0]) {
+			if seen[letter] {
+				return l, fmt.Errorf("Bad axis %q, %c is already used", field, letter)
+			}
+			seen[letter] = true
+		}
+		if size > maxSeats || l.seats()*size > maxSeats {
+			return l, fmt.Errorf("Bad layout %q, it can have at most %d seats", spec, maxSeats)
+		}
+		var bits int
+		for 1<<bits < size {
+			bits++
+		}
+		l.axes = append(l.axes, axis{parts[0][0], parts[0][1], bits})
 	}
+	return l, nil
 }
 
-func findSeatColumn(seat string) int {
-	var seatNumber []byte
-	if len(seat) != 3 {
-		fmt.Printf("Bad seat ID: %s, length %d instead of 3\n", seat, len(seat))
-		return 0
+func (l layout) passLength() int {
+	var length int
+	for _, a := range l.axes {
+		length += a.bits
 	}
-	for _, c := range seat {
-		switch c {
-		case 'L':
-			seatNumber = append(seatNumber, '0')
-		case 'R':
-			seatNumber = append(seatNumber, '1')
-		default:
-			fmt.Printf("Error, bad position identifier in %s: %c\n", seat, c)
-			return 0
+	return length
+}
+
+func (l layout) seats() int {
+	seats := 1
+	for _, a := range l.axes {
+		seats *= a.size()
+	}
+	return seats
+}
+
+/* Splits a boarding pass into its position along each axis */
+func (l layout) decode(pass string) ([]int, error) {
+	if len(pass) != l.passLength() {
+		return nil, fmt.Errorf("Error, pass %s has length %d instead of %d", pass, len(pass), l.passLength())
+	}
+	var position []int
+	for _, a := range l.axes {
+		coordinate, err := a.decode(pass[:a.bits])
+		if err != nil {
+			return nil, err
 		}
+		position = append(position, coordinate)
+		pass = pass[a.bits:]
 	}
-	if row, err := strconv.ParseUint(string(seatNumber), 2, 4); err != nil {
-		fmt.Println(err)
-		return 0
-	} else {
-		return int(row)
+	return position, nil
+}
+
+/* The seat ID counts along the last axis first, so row * 8 + column for the default layout */
+func (l layout) seatID(position []int) (int, error) {
+	if len(position) != len(l.axes) {
+		return 0, fmt.Errorf("Expected %d coordinates, got %d", len(l.axes), len(position))
+	}
+	var id int
+	for i, a := range l.axes {
+		if position[i] < 0 || position[i] >= a.size() {
+			return 0, fmt.Errorf("Coordinate %d is out of bounds: %d", i, position[i])
+		}
+		id = id*a.size() + position[i]
+	}
+	return id, nil
+}
+
+func (a axis) encode(position int) (string, error) {
+	if position < 0 || position >= a.size() {
+		return "", fmt.Errorf("Position %d is out of bounds for %c%c", position, a.lower, a.upper)
 	}
+	code := make([]byte, a.bits)
+	for i := a.bits - 1; i >= 0; i-- {
+		if position&1 == 1 {
+			code[i] = a.upper
+		} else {
+			code[i] = a.lower
+		}
+		position >>= 1
+	}
+	return string(code), nil
 }
 
-func generateSeatID(row int, column int) int {
-	if row < 0 || row > 127 {
-		fmt.Println("Row is out of bounds", row)
-		return 0
+func (l layout) encode(position []int) (string, error) {
+	if len(position) != len(l.axes) {
+		return "", fmt.Errorf("Expected %d coordinates, got %d", len(l.axes), len(position))
 	}
-	if column < 0 || column > 7 {
-		fmt.Println("Column is out of bounds", column)
-		return 0
+	var pass strings.Builder
+	for i, a := range l.axes {
+		code, err := a.encode(position[i])
+		if err != nil {
+			return "", err
+		}
+		pass.WriteString(code)
 	}
-	return (row * 8) + column
+	return pass.String(), nil
 }
 
-func generateSeatIDs(inputData []string) []int {
+/* The inverse of seatID */
+func (l layout) position(id int) ([]int, error) {
+	if id < 0 || id >= l.seats() {
+		return nil, fmt.Errorf("Seat ID %d is out of bounds", id)
+	}
+	position := make([]int, len(l.axes))
+	for i := len(l.axes) - 1; i >= 0; i-- {
+		position[i] = id % l.axes[i].size()
+		id /= l.axes[i].size()
+	}
+	return position, nil
+}
+
+/* Each line is either a seat ID or a comma separated position, e.g. "44,5" */
+func encodeSeats(inputData []string, l layout) ([]string, error) {
+	var passes []string
+	for _, line := range inputData {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var position []int
+		if strings.Contains(line, ",") {
+			for _, field := range strings.Split(line, ",") {
+				coordinate, err := strconv.Atoi(strings.TrimSpace(field))
+				if err != nil {
+					return nil, err
+				}
+				position = append(position, coordinate)
+			}
+		} else {
+			id, err := strconv.Atoi(line)
+			if err != nil {
+				return nil, err
+			}
+			if position, err = l.position(id); err != nil {
+				return nil, err
+			}
+		}
+		pass, err := l.encode(position)
+		if err != nil {
+			return nil, err
+		}
+		passes = append(passes, pass)
+	}
+	return passes, nil
+}
+
+func generateSeatIDs(inputData []string, l layout) []int {
 	var seatIDs []int
 
 	for _, line := range inputData {
 		if len(line) == 0 {
 			continue
 		}
-		if len(line) != 10 {
-			fmt.Fprintf(os.Stderr, "Error, short line: %v\n", line)
+		position, err := l.decode(line)
+		if err != nil {
+			logger.Warn("Skipping boarding pass", "pass", line, "err", err)
+			continue
+		}
+		id, err := l.seatID(position)
+		if err != nil {
+			logger.Warn("Skipping boarding pass", "pass", line, "err", err)
 			continue
 		}
-		row := line[:7]
-		column := line[7:]
-		seatIDs = append(seatIDs, generateSeatID(findSeatRow(row), findSeatColumn(column)))
+		seatIDs = append(seatIDs, id)
+		if tracer != nil {
+			tracer.Info("pass", "pass", line, "position", position, "id", id)
+		}
 	}
 	return seatIDs
 }
@@ -120,44 +307,271 @@ func findHighestSeatID(seatIDs []int) (int, error) {
 	return highest[0], nil
 }
 
-func findMissingSeat(seatIDs []int) (int, error) {
-	var last int
+/*
+ * Every unoccupied seat, split into those in front of the first occupied seat,
+ * those behind the last and the gaps in between. Yours is a gap with both
+ * neighbours occupied.
+ */
+type vacancies struct {
+	front    []int
+	interior []int
+	back     []int
+	yours    []int
+}
+
+func findVacancies(seatIDs []int, seats int) (vacancies, error) {
+	var v vacancies
 	if 0 == len(seatIDs) {
-		return 0, errors.New("Not passed any seat IDs")
+		return v, errors.New("Not passed any seat IDs")
+	}
+	occupied := make([]bool, seats)
+	first, last := seats, -1
+	for _, id := range seatIDs {
+		if id < 0 || id >= seats {
+			return v, fmt.Errorf("Seat ID %d is out of bounds", id)
+		}
+		occupied[id] = true
+		if id < first {
+			first = id
+		}
+		if id > last {
+			last = id
+		}
+	}
+
+	for id := 0; id < seats; id++ {
+		switch {
+		case occupied[id]:
+		case id < first:
+			v.front = append(v.front, id)
+		case id > last:
+			v.back = append(v.back, id)
+		default:
+			v.interior = append(v.interior, id)
+			if occupied[id-1] && occupied[id+1] {
+				v.yours = append(v.yours, id)
+			}
+		}
+	}
+	return v, nil
+}
+
+/* Part 2: the one vacancy with both neighbours occupied, as findVacancies sees it */
+func findMissingSeat(seatIDs []int, seats int) (int, error) {
+	v, err := findVacancies(seatIDs, seats)
+	if err != nil {
+		return 0, err
+	}
+	switch len(v.yours) {
+	case 0:
+		return 0, errors.New("No missing seat found")
+	case 1:
+		return v.yours[0], nil
+	}
+	return 0, fmt.Errorf("More than one seat could be yours: %s", formatRanges(v.yours))
+}
+
+/* Collapses sorted IDs into runs, e.g. "0-47, 50" */
+func formatRanges(ids []int) string {
+	if len(ids) == 0 {
+		return "none"
+	}
+	var ranges []string
+	start := ids[0]
+	for i := 1; i <= len(ids); i++ {
+		if i < len(ids) && ids[i] == ids[i-1]+1 {
+			continue
+		}
+		if start == ids[i-1] {
+			ranges = append(ranges, strconv.Itoa(start))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", start, ids[i-1]))
+		}
+		if i < len(ids) {
+			start = ids[i]
+		}
+	}
+	return strings.Join(ranges, ", ")
+}
+
+func vacancyReport(v vacancies) []string {
+	return []string{
+		fmt.Sprintf("front: %s (%d seats)", formatRanges(v.front), len(v.front)),
+		fmt.Sprintf("interior: %s (%d seats)", formatRanges(v.interior), len(v.interior)),
+		fmt.Sprintf("back: %s (%d seats)", formatRanges(v.back), len(v.back)),
+		fmt.Sprintf("yours: %s", formatRanges(v.yours)),
+	}
+}
+
+/*
+ * One line per row with the last axis across the page: # for an occupied
+ * seat, . for a vacant one and Y for yours
+ */
+func seatMap(seatIDs []int, l layout, yours []int) []string {
+	width := l.axes[len(l.axes)-1].size()
+	rows := l.seats() / width
+	labelWidth := len(strconv.Itoa(rows - 1))
+	grid := make([][]byte, rows)
+	for row := range grid {
+		grid[row] = []byte(strings.Repeat(".", width))
+	}
+	for _, id := range seatIDs {
+		if id >= 0 && id < l.seats() {
+			grid[id/width][id%width] = '#'
+		}
+	}
+	for _, id := range yours {
+		grid[id/width][id%width] = 'Y'
+	}
+
+	var lines []string
+	for row, seats := range grid {
+		lines = append(lines, fmt.Sprintf("%*d %s", labelWidth, row, seats))
+	}
+	return lines
+}
+
+/*
+ * A full run of seats bar one in the middle, in a random order. Seat IDs are
+ * written straight out as bits, one letter per bit, rather than going through
+ * encode.
+ */
+func generateBoardingPasses(random *rand.Rand, l layout, size int) ([]string, int, int, error) {
+	if l.seats() < 3 {
+		return nil, 0, 0, fmt.Errorf("A layout needs at least 3 seats to have a free one between two others, this one has %d", l.seats())
+	}
+	if size > l.seats()-1 {
+		size = l.seats() - 1
 	}
-	for _, seat := range seatIDs {
-		if 0 == last {
-			last = seat
+	if size < 2 {
+		size = 2
+	}
+	first := random.Intn(l.seats() - size)
+	last := first + size
+	yours := first + 1 + random.Intn(size-1)
+
+	var letters [][2]byte
+	for _, a := range l.axes {
+		for bit := 0; bit < a.bits; bit++ {
+			letters = append(letters, [2]byte{a.lower, a.upper})
+		}
+	}
+	var lines []string
+	for id := first; id <= last; id++ {
+		if id == yours {
 			continue
 		}
-		if last != seat-1 {
-			return seat - 1, nil
+		pass := make([]byte, len(letters))
+		for i := range pass {
+			pass[i] = letters[i][id>>(len(letters)-1-i)&1]
 		}
-		last = seat
+		lines = append(lines, string(pass))
+	}
+	random.Shuffle(len(lines), func(i, j int) {
+		lines[i], lines[j] = lines[j], lines[i]
+	})
+	return lines, last, yours, nil
+}
+
+func writeGenerated(prefix string, lines []string, part1 int, part2 int) error {
+	if err := ioutil.WriteFile(prefix, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
 	}
-	return 0, errors.New("No missing seat found")
+	return ioutil.WriteFile(prefix+".answers", []byte(fmt.Sprintf("%d\n%d\n", part1, part2)), 0644)
 }
 
 func main() {
 	var fileName string
+	var layoutSpec string
+	var encode bool
+	var report bool
+	var showMap bool
+	var generate string
+	var seed int64
+	var size int
 	var part2 bool
+	var verbose bool
+	var veryVerbose bool
+	var traceFile string
 
 	flag.StringVar(&fileName, "f", "input", "Input file")
+	flag.StringVar(&layoutSpec, "layout", "FB:128,LR:8", "Letter pair and size of each axis of the plane")
+	flag.BoolVar(&encode, "encode", false, "Convert seat IDs or row,column positions in the input to boarding passes")
+	flag.BoolVar(&report, "vacancies", false, "List every unoccupied seat")
+	flag.BoolVar(&showMap, "map", false, "Draw a map of the plane's seats")
+	flag.StringVar(&generate, "generate", "", "Write a random input to this file and its answers to the file plus .answers")
+	flag.Int64Var(&seed, "seed", 1, "Seed for -generate")
+	flag.IntVar(&size, "size", 800, "Number of boarding passes for -generate")
+	flag.BoolVar(&verbose, "v", false, "Log progress to STDERR")
+	flag.BoolVar(&veryVerbose, "vv", false, "Log progress and details to STDERR")
+	flag.StringVar(&traceFile, "trace", "", "Write each boarding pass decoded to this file as JSON lines")
 	flag.BoolVar(&part2, "2", false, "Compute part 2 of the exercise")
 	flag.Parse()
 
+	flush, err := setupLogging(verbose, veryVerbose, traceFile)
+	if err != nil {
+		die(err)
+	}
+	closeTrace = flush
+	defer closeTrace()
+
+	l, err := parseLayout(layoutSpec)
+	if err != nil {
+		die(err)
+	}
+	logger.Debug("Parsed layout", "layout", layoutSpec, "seats", l.seats())
+
+	if generate != "" {
+		lines, part1Answer, part2Answer, err := generateBoardingPasses(rand.New(rand.NewSource(seed)), l, size)
+		if err != nil {
+			die(err)
+		}
+		if err := writeGenerated(generate, lines, part1Answer, part2Answer); err != nil {
+			die(err)
+		}
+		return
+	}
+
 	inputData, err := readFile(fileName)
 	if err != nil {
 		die(err)
 	}
 
-	seatIDs := generateSeatIDs(inputData)
+	if encode {
+		passes, err := encodeSeats(inputData, l)
+		if err != nil {
+			die(err)
+		}
+		for _, pass := range passes {
+			fmt.Println(pass)
+		}
+		return
+	}
+
+	seatIDs := generateSeatIDs(inputData, l)
+	logger.Info("Read boarding passes", "file", fileName, "seats", len(seatIDs))
+	if report || showMap {
+		v, err := findVacancies(seatIDs, l.seats())
+		if err != nil {
+			die(err)
+		}
+		var lines []string
+		if report {
+			lines = append(lines, vacancyReport(v)...)
+		}
+		if showMap {
+			lines = append(lines, seatMap(seatIDs, l, v.yours)...)
+		}
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+		return
+	}
+
 	if part2 {
-		sort.Ints(seatIDs)
-		seat, err := findMissingSeat(seatIDs)
+		seat, err := findMissingSeat(seatIDs, l.seats())
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Unable to find a seat")
-			os.Exit(1)
+			die(fmt.Errorf("Unable to find a seat: %s", err))
 		}
 		fmt.Println(seat)
 	} else {
@@ -2,8 +2,10 @@ package main
 
 import (
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -121,148 +123,387 @@ func TestReadFileSingleNewline(t *testing.T) {
 	}
 }
 
-func TestFindSeatRow(t *testing.T) {
-	seat := "FBFBBFF"
-	row := findSeatRow(seat)
-	if row != 44 {
-		t.Log("Expected row 44, got", row)
+func TestAxisDecode(t *testing.T) {
+	rows, columns := defaultLayout.axes[0], defaultLayout.axes[1]
+	if row, err := rows.decode("FBFBBFF"); err != nil || row != 44 {
+		t.Log("Expected row 44, got", row, err)
 		t.Fail()
 	}
-
-	seat = "FBFBBF"
-	row = findSeatRow(seat)
-	if row != 0 {
-		t.Log("Got row 0 (too short), got", row)
+	if column, err := columns.decode("RLR"); err != nil || column != 5 {
+		t.Log("Expected column 5, got", column, err)
 		t.Fail()
 	}
 
-	seat = "FBFBBFFF"
-	row = findSeatRow(seat)
-	if row != 0 {
-		t.Log("Got row 0 (too long), got", row)
+	for _, bad := range []string{"FBFBBF", "FBFBBFFF", "9FBFBBF", "RLRLRLR"} {
+		if row, err := rows.decode(bad); err == nil {
+			t.Log("Expected error decoding row", bad, "got", row)
+			t.Fail()
+		}
+	}
+	for _, bad := range []string{"RR", "RLRL", "9RL", "FBF"} {
+		if column, err := columns.decode(bad); err == nil {
+			t.Log("Expected error decoding column", bad, "got", column)
+			t.Fail()
+		}
+	}
+}
+
+func TestLayoutSeatID(t *testing.T) {
+	for _, seat := range []struct {
+		position []int
+		id       int
+	}{{[]int{44, 5}, 357}, {[]int{127, 1}, 1017}, {[]int{1, 1}, 9}} {
+		if id, err := defaultLayout.seatID(seat.position); err != nil || id != seat.id {
+			t.Log("Expected seat ID", seat.id, "for", seat.position, "got", id, err)
+			t.Fail()
+		}
+	}
+
+	for _, bad := range [][]int{{-1, 5}, {128, 1}, {1, -1}, {1, 8}, {1}} {
+		if id, err := defaultLayout.seatID(bad); err == nil {
+			t.Log("Expected error for out of bounds seat", bad, "got", id)
+			t.Fail()
+		}
+	}
+}
+
+func TestFindHighestSeatID(t *testing.T) {
+	var seatID int
+	var err error
+	seatID, err = findHighestSeatID([]int{4, 6, 7, 2, 1, 9})
+	if seatID != 9 {
+		t.Log("Wrong seat ID, expected 9, got", seatID)
 		t.Fail()
 	}
 
-	seat = "9FBFBBF"
-	row = findSeatRow(seat)
-	if row != 0 {
-		t.Log("Got row 0 (non-F|B character), got", row)
+	seatID, err = findHighestSeatID([]int{})
+	if err == nil {
+		t.Log("Expected an error, for an empty seat array but didn't get one")
 		t.Fail()
 	}
 }
 
-func TestFindSeatColumn(t *testing.T) {
-	column := findSeatColumn("RLR")
-	if column != 5 {
-		t.Log("Expected column 5, got", column)
+func TestFindMissingSeat(t *testing.T) {
+	var seat int
+	var err error
+
+	seat, err = findMissingSeat([]int{1, 2, 3, 5, 6, 7}, 8)
+	if err != nil {
+		t.Log("Unexpectedly got an error testing findMissingSeat", err)
+		t.Fail()
+	}
+	if seat != 4 {
+		t.Log("Expected seat 4, got", seat)
 		t.Fail()
 	}
 
-	column = findSeatColumn("RR")
-	if column != 0 {
-		t.Log("Got column 0 (too short), got", column)
+	seat, err = findMissingSeat([]int{1, 2}, 8)
+	if err == nil {
+		t.Log("Expected an error and got none testing no missing seat")
 		t.Fail()
 	}
 
-	column = findSeatColumn("RLRL")
-	if column != 0 {
-		t.Log("Got column 0 (too long), got", column)
+	seat, err = findMissingSeat([]int{}, 8)
+	if err == nil {
+		t.Log("Expected an error and testing empty input for findMissingSeat")
 		t.Fail()
 	}
 
-	column = findSeatColumn("9RL")
-	if column != 0 {
-		t.Log("Got column 0 (non-L|R character), got", column)
+	seat, err = findMissingSeat([]int{1, 2, 2, 3, 5}, 8)
+	if err != nil || seat != 4 {
+		t.Log("Expected seat 4 despite the duplicate, got", seat, err)
+		t.Fail()
+	}
+
+	seat, err = findMissingSeat([]int{1, 3, 5}, 8)
+	if err == nil {
+		t.Log("Expected an error for two candidate seats, got", seat)
 		t.Fail()
 	}
 }
 
-func TestGenerateSeatID(t *testing.T) {
-	seatID := generateSeatID(44, 5)
-	if seatID != 357 {
-		t.Log("Wrong seat ID, expected 357, got", seatID)
+func TestParseLayout(t *testing.T) {
+	l, err := parseLayout("FB:128,LR:8")
+	if err != nil {
+		t.Log("Unexpected error parsing the default layout", err)
+		t.Fail()
+	}
+	if !reflect.DeepEqual(l, defaultLayout) {
+		t.Log("Error, expected the default layout, got", l)
 		t.Fail()
 	}
 
-	// Top of allowable row
-	seatID = generateSeatID(127, 1)
-	if seatID != 1017 {
-		t.Log("Wrong seat ID, expected 1017, got", seatID)
+	for _, bad := range []string{"", "FB", "FB:100", "FB:1", "FF:8", "FBX:8", "FB:8,BX:4", "FB:x", "FB:33554432", "FB:4096,LR:4096,UD:2"} {
+		if l, err = parseLayout(bad); err == nil {
+			t.Log("Expected error parsing layout", bad, "got", l)
+			t.Fail()
+		}
+	}
+}
+
+func TestLayoutDecode(t *testing.T) {
+	position, err := defaultLayout.decode("FBFBBFFRLR")
+	if err != nil || !reflect.DeepEqual(position, []int{44, 5}) {
+		t.Log("Expected row 44 column 5, got", position, err)
 		t.Fail()
 	}
 
-	// Top of allowable column
-	seatID = generateSeatID(1, 1)
-	if seatID != 9 {
-		t.Log("Wrong seat ID, expected 9, got", seatID)
+	l, err := parseLayout("UD:4,LR:2,NS:8")
+	if err != nil {
+		t.Log("Unexpected error", err)
+		t.FailNow()
+	}
+	position, err = l.decode("DURNSN")
+	if err != nil || !reflect.DeepEqual(position, []int{2, 1, 2}) {
+		t.Log("Expected position 2,1,2 got", position, err)
+		t.Fail()
+	}
+	id, err := l.seatID(position)
+	if err != nil || id != 2*16+1*8+2 {
+		t.Log("Expected seat ID 42, got", id, err)
+		t.Fail()
+	}
+	if l.seats() != 64 {
+		t.Log("Expected 64 seats, got", l.seats())
 		t.Fail()
 	}
 
-	seatID = generateSeatID(-1, 5)
-	if seatID != 0 {
-		t.Log("Wrong seat ID, expected 0 (negative row), got", seatID)
+	for _, bad := range []string{"DURNS", "DURNSNS", "DUXNSN", "LURNSN"} {
+		if position, err = l.decode(bad); err == nil {
+			t.Log("Expected error decoding", bad, "got", position)
+			t.Fail()
+		}
+	}
+	if id, err = l.seatID([]int{1, 2, 0}); err == nil {
+		t.Log("Expected error for out of bounds position, got", id)
 		t.Fail()
 	}
+}
 
-	seatID = generateSeatID(128, 1)
-	if seatID != 0 {
-		t.Log("Wrong seat ID, expected 0 (high row), got", seatID)
+func TestGenerateSeatIDs(t *testing.T) {
+	seatIDs := generateSeatIDs([]string{"BFFFBBFRRR", "", "FFFBBBFRRR", "BBFFBBFRLL", "BBFFBBFRLX"}, defaultLayout)
+	if !reflect.DeepEqual(seatIDs, []int{567, 119, 820}) {
+		t.Log("Expected seat IDs 567, 119, 820, got", seatIDs)
 		t.Fail()
 	}
+}
 
-	seatID = generateSeatID(1, -1)
-	if seatID != 0 {
-		t.Log("Wrong seat ID, expected 0 (negative column), got", seatID)
+func TestLayoutEncode(t *testing.T) {
+	pass, err := defaultLayout.encode([]int{44, 5})
+	if err != nil || pass != "FBFBBFFRLR" {
+		t.Log("Expected FBFBBFFRLR, got", pass, err)
 		t.Fail()
 	}
 
-	seatID = generateSeatID(1, 8)
-	if seatID != 0 {
-		t.Log("Wrong seat ID, expected 0 (high column), got", seatID)
+	if pass, err = defaultLayout.encode([]int{128, 5}); err == nil {
+		t.Log("Expected error for out of bounds row, got", pass)
+		t.Fail()
+	}
+	if pass, err = defaultLayout.encode([]int{44}); err == nil {
+		t.Log("Expected error for missing column, got", pass)
 		t.Fail()
 	}
-}
 
-func TestFindHighestSeatID(t *testing.T) {
-	var seatID int
-	var err error
-	seatID, err = findHighestSeatID([]int{4, 6, 7, 2, 1, 9})
-	if seatID != 9 {
-		t.Log("Wrong seat ID, expected 9, got", seatID)
+	position, err := defaultLayout.position(357)
+	if err != nil || !reflect.DeepEqual(position, []int{44, 5}) {
+		t.Log("Expected row 44 column 5, got", position, err)
 		t.Fail()
 	}
+	if position, err = defaultLayout.position(1024); err == nil {
+		t.Log("Expected error for out of bounds seat ID, got", position)
+		t.Fail()
+	}
+}
 
-	seatID, err = findHighestSeatID([]int{})
-	if err == nil {
-		t.Log("Expected an error, for an empty seat array but didn't get one")
+/* Every seat on the plane must survive encoding and decoding */
+func TestLayoutRoundTrip(t *testing.T) {
+	for _, l := range []layout{defaultLayout, {axes: []axis{{'U', 'D', 2}, {'L', 'R', 1}, {'N', 'S', 3}}}} {
+		for id := 0; id < l.seats(); id++ {
+			position, err := l.position(id)
+			if err != nil {
+				t.Log("Unexpected error for seat", id, err)
+				t.FailNow()
+			}
+			pass, err := l.encode(position)
+			if err != nil {
+				t.Log("Unexpected error encoding seat", id, err)
+				t.FailNow()
+			}
+			decoded, err := l.decode(pass)
+			if err != nil {
+				t.Log("Unexpected error decoding", pass, err)
+				t.FailNow()
+			}
+			result, err := l.seatID(decoded)
+			if err != nil || result != id {
+				t.Log("Error, seat", id, "encoded as", pass, "decoded as", result, err)
+				t.FailNow()
+			}
+		}
+	}
+}
+
+func TestEncodeSeats(t *testing.T) {
+	passes, err := encodeSeats([]string{"357", "", "70,7", " 119 "}, defaultLayout)
+	if err != nil {
+		t.Log("Unexpected error:", err)
+		t.Fail()
+	}
+	expected := []string{"FBFBBFFRLR", "BFFFBBFRRR", "FFFBBBFRRR"}
+	if !reflect.DeepEqual(passes, expected) {
+		t.Log("Expected", expected, "got", passes)
 		t.Fail()
 	}
+
+	for _, bad := range []string{"seat", "1,x", "1,2,3", "-1", "1024"} {
+		if passes, err = encodeSeats([]string{bad}, defaultLayout); err == nil {
+			t.Log("Expected error encoding", bad, "got", passes)
+			t.Fail()
+		}
+	}
 }
 
-func TestFindMissingSeat(t *testing.T) {
-	var seat int
-	var err error
+func TestFindMissingSeatFromZero(t *testing.T) {
+	seat, err := findMissingSeat([]int{0, 1, 3}, 8)
+	if err != nil || seat != 2 {
+		t.Log("Expected seat 2, got", seat, err)
+		t.Fail()
+	}
+}
 
-	seat, err = findMissingSeat([]int{1, 2, 3, 5, 6, 7})
+func TestFindVacancies(t *testing.T) {
+	v, err := findVacancies([]int{3, 0, 1, 6, 7, 9}, 12)
 	if err != nil {
-		t.Log("Unexpectedly got an error testing findMissingSeat", err)
+		t.Log("Unexpected error:", err)
 		t.Fail()
 	}
-	if seat != 4 {
-		t.Log("Expected seat 4, got", seat)
+	expected := vacancies{
+		interior: []int{2, 4, 5, 8},
+		back:     []int{10, 11},
+		yours:    []int{2, 8},
+	}
+	if !reflect.DeepEqual(v, expected) {
+		t.Log("Expected", expected, "got", v)
 		t.Fail()
 	}
 
-	seat, err = findMissingSeat([]int{1, 2})
-	if err == nil {
-		t.Log("Expected an error and got none testing no missing seat")
+	v, err = findVacancies([]int{2, 3}, 6)
+	if err != nil || !reflect.DeepEqual(v.front, []int{0, 1}) || v.interior != nil {
+		t.Log("Expected seats 0 and 1 vacant at the front, got", v, err)
 		t.Fail()
 	}
 
-	seat, err = findMissingSeat([]int{})
-	if err == nil {
-		t.Log("Expected an error and testing empty input for findMissingSeat")
+	if _, err = findVacancies([]int{}, 6); err == nil {
+		t.Log("Expected an error for no seats")
+		t.Fail()
+	}
+	if _, err = findVacancies([]int{6}, 6); err == nil {
+		t.Log("Expected an error for an out of bounds seat")
 		t.Fail()
 	}
+}
 
+func TestFormatRanges(t *testing.T) {
+	for ids, expected := range map[string]string{
+		"":            "none",
+		"4":           "4",
+		"0 1 2 5":     "0-2, 5",
+		"1 3 4 7 8 9": "1, 3-4, 7-9",
+	} {
+		var list []int
+		for _, field := range strings.Fields(ids) {
+			id, _ := strconv.Atoi(field)
+			list = append(list, id)
+		}
+		if result := formatRanges(list); result != expected {
+			t.Log("Expected", expected, "for", ids, "got", result)
+			t.Fail()
+		}
+	}
+}
+
+func TestSeatMap(t *testing.T) {
+	l, err := parseLayout("FB:4,LR:4")
+	if err != nil {
+		t.Log("Unexpected error", err)
+		t.FailNow()
+	}
+	lines := seatMap([]int{4, 5, 7, 8, 9, 10, 11}, l, []int{6})
+	expected := []string{
+		"0 ....",
+		"1 ##Y#",
+		"2 ####",
+		"3 ....",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Log("Expected", expected, "got", lines)
+		t.Fail()
+	}
+}
+
+func TestGenerateBoardingPasses(t *testing.T) {
+	small, _ := parseLayout("UD:4,AB:2,XY:4")
+	for _, l := range []layout{defaultLayout, small} {
+		for _, size := range []int{1, 5, 800, 2000} {
+			lines, part1, part2, err := generateBoardingPasses(rand.New(rand.NewSource(int64(size))), l, size)
+			if err != nil {
+				t.Log("Failed to generate boarding passes:", err)
+				t.Fail()
+				continue
+			}
+			seatIDs := generateSeatIDs(lines, l)
+			if len(seatIDs) != len(lines) {
+				t.Log("Error, generated passes that don't decode", lines)
+				t.Fail()
+				continue
+			}
+			if highest, err := findHighestSeatID(seatIDs); err != nil || highest != part1 {
+				t.Log("Expected highest seat", part1, "got", highest, err)
+				t.Fail()
+			}
+			if seat, err := findMissingSeat(seatIDs, l.seats()); err != nil || seat != part2 {
+				t.Log("Expected missing seat", part2, "got", seat, err)
+				t.Fail()
+			}
+		}
+	}
+
+	tiny, _ := parseLayout("LR:2")
+	if lines, _, _, err := generateBoardingPasses(rand.New(rand.NewSource(1)), tiny, 800); err == nil {
+		t.Log("Expected error generating for two seats, got", lines)
+		t.Fail()
+	}
+}
+
+func FuzzGenerateSeatIDs(f *testing.F) {
+	f.Add("FBFBBFFRLR\nBFFFBBFRRR\nFFFBBBFRRR\nBBFFBBFRLL")
+	f.Add("FBFBBFF\nRLR\n\nFBFBBFFRLRX")
+	f.Fuzz(func(t *testing.T, input string) {
+		for _, pass := range strings.Split(input, "\n") {
+			if position, err := defaultLayout.decode(pass); err == nil {
+				if _, err := defaultLayout.seatID(position); err != nil {
+					t.Log("Error, decoded", pass, "to", position, "which is out of range:", err)
+					t.Fail()
+				}
+			}
+		}
+
+		seatIDs := generateSeatIDs(strings.Split(input, "\n"), defaultLayout)
+		for _, id := range seatIDs {
+			position, err := defaultLayout.position(id)
+			if err != nil {
+				t.Log("Error, seat ID", id, "out of range:", err)
+				t.Fail()
+				continue
+			}
+			pass, err := defaultLayout.encode(position)
+			if err != nil || !strings.Contains(input, pass) {
+				t.Log("Error, seat ID", id, "encodes to", pass, "which isn't in the input", err)
+				t.Fail()
+			}
+		}
+		if v, err := findVacancies(seatIDs, defaultLayout.seats()); err == nil {
+			seatMap(seatIDs, defaultLayout, v.yours)
+		}
+	})
 }
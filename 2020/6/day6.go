@@ -1,20 +1,63 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log/slog"
+	"math/big"
+	"math/rand"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 )
 
 /* Errors should be printed to STDERR not STDOUT */
 func die(err error) {
 	fmt.Fprintf(os.Stderr, "%s\n", err)
+	closeTrace()
 	os.Exit(1)
 }
 
+var logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+var tracer *slog.Logger
+
+var closeTrace = func() {}
+
+func setupLogging(verbose bool, veryVerbose bool, traceFile string) (func(), error) {
+	level := slog.LevelWarn
+	if verbose {
+		level = slog.LevelInfo
+	}
+	if veryVerbose {
+		level = slog.LevelDebug
+	}
+	logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	if traceFile == "" {
+		return func() {}, nil
+	}
+	file, err := os.Create(traceFile)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewWriter(file)
+	tracer = slog.New(slog.NewJSONHandler(buffered, nil))
+	return func() {
+		if err := buffered.Flush(); err != nil {
+			logger.Warn("Failed to write the trace", "file", traceFile, "err", err)
+		}
+		if err := file.Close(); err != nil {
+			logger.Warn("Failed to close the trace", "file", traceFile, "err", err)
+		}
+	}, nil
+}
+
 func readFile(filename string) ([]string, error) {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
@@ -29,89 +72,406 @@ func readFile(filename string) ([]string, error) {
 	return lines, nil
 }
 
-func findAnswered(form []string) map[string]bool {
-	questions := make(map[string]bool)
+/* How many people in the group answered each question */
+func countAnswers(form []string) map[string]int {
+	answers := make(map[string]int)
 	for _, line := range form {
 		for _, answer := range strings.Split(line, "") {
 			if answer != "" {
-				questions[answer] = true
+				answers[answer]++
 			}
 		}
 	}
+	return answers
+}
+
+func findAnswered(form []string) map[string]bool {
+	questions := make(map[string]bool)
+	for question := range countAnswers(form) {
+		questions[question] = true
+	}
 	return questions
 }
 
-func countAnswered(forms map[int][]string) int {
+func countAnswered(groups []group) int {
 	var count int
-	for _, form := range forms {
-		answeredQuestions := findAnswered(form)
+	for _, g := range groups {
+		answeredQuestions := findAnswered(g.forms)
 		count += len(answeredQuestions)
+		if tracer != nil {
+			tracer.Info("group", "start_line", g.startLine, "size", len(g.forms), "questions", sortedQuestions(answeredQuestions), "count", count)
+		}
 	}
 	return count
 }
 
 func findEveryoneAnswered(form []string) map[string]bool {
-	questions := make(map[string]int)
 	everyoneAnswered := make(map[string]bool)
-	groupSize := len(form)
-	for _, line := range form {
-		for _, answer := range strings.Split(line, "") {
-			if answer != "" {
-				questions[answer]++
-			}
-		}
-	}
-
-	for question, count := range questions {
-		if count == groupSize {
+	for question, count := range countAnswers(form) {
+		if count == len(form) {
 			everyoneAnswered[question] = true
 		}
 	}
 	return everyoneAnswered
 }
 
-func countEveryoneAnswered(forms map[int][]string) int {
+func countEveryoneAnswered(groups []group) int {
 	var count int
-	for _, form := range forms {
-		answeredQuestions := findEveryoneAnswered(form)
+	for _, g := range groups {
+		answeredQuestions := findEveryoneAnswered(g.forms)
 		count += len(answeredQuestions)
+		if tracer != nil {
+			tracer.Info("group", "start_line", g.startLine, "size", len(g.forms), "questions", sortedQuestions(answeredQuestions), "count", count)
+		}
 	}
 	return count
 }
 
-func collectForms(inputData []string) map[int][]string {
-	customsForms := make(map[int][]string)
+/*
+ * A quantifier picks the questions to count in each group by how many people
+ * answered them: atleast:K, atmost:K, exactly:K or fraction:F, where F is a
+ * share of the group such as 0.5 or 2/3. Questions nobody answered are never
+ * counted.
+ */
+type quantifier struct {
+	kind     string
+	count    int
+	fraction *big.Rat
+}
+
+func parseQuantifier(spec string) (quantifier, error) {
+	var q quantifier
+	parts := strings.Split(spec, ":")
+	if len(parts) != 2 {
+		return q, fmt.Errorf("Bad query %q, expected kind:value", spec)
+	}
+	q.kind = parts[0]
+	switch q.kind {
+	case "atleast", "atmost", "exactly":
+		count, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return q, err
+		}
+		if count < 0 {
+			return q, fmt.Errorf("Bad query %q, count must not be negative", spec)
+		}
+		q.count = count
+	case "fraction":
+		fraction, ok := new(big.Rat).SetString(parts[1])
+		if !ok || fraction.Sign() < 0 || fraction.Cmp(big.NewRat(1, 1)) > 0 {
+			return q, fmt.Errorf("Bad query %q, fraction must be between 0 and 1", spec)
+		}
+		q.fraction = fraction
+	default:
+		return q, fmt.Errorf("Unknown query %q, expected atleast, atmost, exactly or fraction", q.kind)
+	}
+	return q, nil
+}
+
+func (q quantifier) matches(answered int, groupSize int) bool {
+	if answered == 0 {
+		return false
+	}
+	switch q.kind {
+	case "atleast":
+		return answered >= q.count
+	case "atmost":
+		return answered <= q.count
+	case "exactly":
+		return answered == q.count
+	case "fraction":
+		return big.NewRat(int64(answered), int64(groupSize)).Cmp(q.fraction) >= 0
+	}
+	return false
+}
+
+func countQuery(groups []group, q quantifier) int {
 	var count int
+	for _, g := range groups {
+		for _, answered := range countAnswers(g.forms) {
+			if q.matches(answered, len(g.forms)) {
+				count++
+			}
+		}
+		if tracer != nil {
+			tracer.Info("group", "start_line", g.startLine, "size", len(g.forms), "count", count)
+		}
+	}
+	return count
+}
+
+type questionFrequency struct {
+	question string
+	people   int // everyone who answered it, across all groups
+	groups   int // groups where anyone answered it
+}
+
+func answerHistogram(groups []group) []questionFrequency {
+	totals := make(map[string]*questionFrequency)
+	for _, g := range groups {
+		for question, answered := range countAnswers(g.forms) {
+			if totals[question] == nil {
+				totals[question] = &questionFrequency{question: question}
+			}
+			totals[question].people += answered
+			totals[question].groups++
+		}
+	}
 
-	for _, line := range inputData {
+	var histogram []questionFrequency
+	for _, frequency := range totals {
+		histogram = append(histogram, *frequency)
+	}
+	sort.Slice(histogram, func(i, j int) bool {
+		return histogram[i].question < histogram[j].question
+	})
+	return histogram
+}
+
+/* Bars are scaled so the most answered question fills the width */
+func formatHistogram(histogram []questionFrequency, width int) []string {
+	var most int
+	for _, frequency := range histogram {
+		if frequency.people > most {
+			most = frequency.people
+		}
+	}
+	var lines []string
+	for _, frequency := range histogram {
+		bar := strings.Repeat("#", frequency.people*width/most)
+		lines = append(lines, fmt.Sprintf("%s %5d %5d %s", frequency.question, frequency.people, frequency.groups, bar))
+	}
+	return lines
+}
+
+/* One person's answers per form, with where the group sits in the input */
+type group struct {
+	forms     []string
+	startLine int // from 1
+	endLine   int
+}
+
+func collectForms(inputData []string) []group {
+	var groups []group
+	var current group
+
+	for i, line := range inputData {
 		if line != "" {
-			customsForms[count] = append(customsForms[count], line)
-		} else {
-			count++
+			if current.forms == nil {
+				current.startLine = i + 1
+			}
+			current.forms = append(current.forms, line)
+			current.endLine = i + 1
+		} else if current.forms != nil {
+			groups = append(groups, current)
+			current = group{}
 		}
 	}
-	return customsForms
+	if current.forms != nil {
+		groups = append(groups, current)
+	}
+	return groups
+}
+
+type groupReport struct {
+	Group        int      `json:"group"`
+	StartLine    int      `json:"start_line"`
+	EndLine      int      `json:"end_line"`
+	Size         int      `json:"size"`
+	Union        []string `json:"union"`
+	Intersection []string `json:"intersection"`
+	Anyone       int      `json:"anyone"`
+	Everyone     int      `json:"everyone"`
+}
+
+func sortedQuestions(questions map[string]bool) []string {
+	sorted := []string{}
+	for question := range questions {
+		sorted = append(sorted, question)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func breakdown(groups []group) []groupReport {
+	var reports []groupReport
+	for i, g := range groups {
+		union := sortedQuestions(findAnswered(g.forms))
+		intersection := sortedQuestions(findEveryoneAnswered(g.forms))
+		reports = append(reports, groupReport{
+			Group:        i + 1,
+			StartLine:    g.startLine,
+			EndLine:      g.endLine,
+			Size:         len(g.forms),
+			Union:        union,
+			Intersection: intersection,
+			Anyone:       len(union),
+			Everyone:     len(intersection),
+		})
+	}
+	return reports
+}
+
+func writeBreakdown(w io.Writer, reports []groupReport, format string) error {
+	switch format {
+	case "text":
+		for _, r := range reports {
+			fmt.Fprintf(w, "group %d (lines %d-%d) size %d: anyone %d [%s] everyone %d [%s]\n",
+				r.Group, r.StartLine, r.EndLine, r.Size,
+				r.Anyone, strings.Join(r.Union, ""), r.Everyone, strings.Join(r.Intersection, ""))
+		}
+		return nil
+	case "json":
+		/* One group per line so two reports can be diffed group by group */
+		encoder := json.NewEncoder(w)
+		for _, r := range reports {
+			if err := encoder.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("Unknown breakdown format %q, expected text or json", format)
+}
+
+/*
+ * Each group starts from the questions everyone answers and the ones only some
+ * answer; the latter go to a random proper subset of the group. Groups where
+ * someone ends up with no answers are drawn again.
+ */
+func generateGroup(random *rand.Rand) ([]string, int, int) {
+	for {
+		people := 1 + random.Intn(5)
+		questions := random.Perm(26)[:1+random.Intn(26)]
+		everyone := questions
+		if people > 1 {
+			everyone = questions[:random.Intn(len(questions)+1)]
+		}
+
+		answers := make([][]byte, people)
+		for _, question := range everyone {
+			for person := range answers {
+				answers[person] = append(answers[person], byte('a'+question))
+			}
+		}
+		for _, question := range questions[len(everyone):] {
+			for _, person := range random.Perm(people)[:1+random.Intn(people-1)] {
+				answers[person] = append(answers[person], byte('a'+question))
+			}
+		}
+
+		var forms []string
+		for _, form := range answers {
+			if len(form) == 0 {
+				break
+			}
+			random.Shuffle(len(form), func(i, j int) {
+				form[i], form[j] = form[j], form[i]
+			})
+			forms = append(forms, string(form))
+		}
+		if len(forms) == people {
+			return forms, len(questions), len(everyone)
+		}
+	}
+}
+
+func generateGroups(random *rand.Rand, size int) ([]string, int, int) {
+	var lines []string
+	var anyone, everyone int
+	for i := 0; i < size; i++ {
+		forms, groupAnyone, groupEveryone := generateGroup(random)
+		if i > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, forms...)
+		anyone += groupAnyone
+		everyone += groupEveryone
+	}
+	return lines, anyone, everyone
+}
+
+func writeGenerated(prefix string, lines []string, part1 int, part2 int) error {
+	if err := ioutil.WriteFile(prefix, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(prefix+".answers", []byte(fmt.Sprintf("%d\n%d\n", part1, part2)), 0644)
 }
 
 func main() {
 	var fileName string
+	var query string
+	var histogram bool
+	var report string
+	var generate string
+	var seed int64
+	var size int
 	var part2 bool
+	var verbose bool
+	var veryVerbose bool
+	var traceFile string
 	var result int
 
 	flag.StringVar(&fileName, "f", "input", "Input file")
+	flag.StringVar(&query, "query", "", "Count questions answered by atleast:K, atmost:K, exactly:K or fraction:F of each group")
+	flag.BoolVar(&histogram, "histogram", false, "Show how often each question was answered instead of a count")
+	flag.StringVar(&report, "breakdown", "", "Show each group's answers as text or json instead of a count")
+	flag.StringVar(&generate, "generate", "", "Write a random input to this file and its answers to the file plus .answers")
+	flag.Int64Var(&seed, "seed", 1, "Seed for -generate")
+	flag.IntVar(&size, "size", 490, "Number of groups for -generate")
+	flag.BoolVar(&verbose, "v", false, "Log progress to STDERR")
+	flag.BoolVar(&veryVerbose, "vv", false, "Log progress and details to STDERR")
+	flag.StringVar(&traceFile, "trace", "", "Write each group counted to this file as JSON lines")
 	flag.BoolVar(&part2, "2", false, "Compute part 2 of the exercise")
 	flag.Parse()
 
+	flush, err := setupLogging(verbose, veryVerbose, traceFile)
+	if err != nil {
+		die(err)
+	}
+	closeTrace = flush
+	defer closeTrace()
+
+	if generate != "" {
+		lines, part1Answer, part2Answer := generateGroups(rand.New(rand.NewSource(seed)), size)
+		if err := writeGenerated(generate, lines, part1Answer, part2Answer); err != nil {
+			die(err)
+		}
+		return
+	}
+
 	inputData, err := readFile(fileName)
 	if err != nil {
 		die(err)
 	}
 
-	forms := collectForms(inputData)
-	if part2 {
-		result = countEveryoneAnswered(forms)
+	groups := collectForms(inputData)
+	logger.Info("Read forms", "file", fileName, "groups", len(groups))
+	if report != "" {
+		if err := writeBreakdown(os.Stdout, breakdown(groups), report); err != nil {
+			die(err)
+		}
+		return
+	}
+
+	if histogram {
+		for _, line := range formatHistogram(answerHistogram(groups), 50) {
+			fmt.Println(line)
+		}
+		return
+	}
+
+	if query != "" {
+		q, err := parseQuantifier(query)
+		if err != nil {
+			die(err)
+		}
+		logger.Debug("Parsed query", "query", query)
+		result = countQuery(groups, q)
+	} else if part2 {
+		result = countEveryoneAnswered(groups)
 	} else {
-		result = countAnswered(forms)
+		result = countAnswered(groups)
 	}
 
 	fmt.Println(result)
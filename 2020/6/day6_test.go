@@ -1,7 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"io/ioutil"
+	"math/big"
+	"math/rand"
 	"os"
 	"reflect"
 	"strings"
@@ -141,6 +144,14 @@ func TestFindAnswered(t *testing.T) {
 	}
 }
 
+var testForms = []group{
+	{forms: []string{"abc"}, startLine: 1, endLine: 1},
+	{forms: []string{"a", "b", "c"}, startLine: 3, endLine: 5},
+	{forms: []string{"ab", "ac"}, startLine: 7, endLine: 8},
+	{forms: []string{"a", "a", "a", "a"}, startLine: 10, endLine: 13},
+	{forms: []string{"b"}, startLine: 15, endLine: 15},
+}
+
 func TestCollectForms(t *testing.T) {
 	response := collectForms([]string{
 		"abc",
@@ -159,12 +170,12 @@ func TestCollectForms(t *testing.T) {
 		"",
 		"b",
 	})
-	if !reflect.DeepEqual(response, map[int][]string{
-		0: {"abc"},
-		1: {"a", "b", "c"},
-		2: {"ab", "ac"},
-		3: {"a", "a", "a", "a"},
-		4: {"b"},
+	if !reflect.DeepEqual(response, []group{
+		{forms: []string{"abc"}, startLine: 1, endLine: 1},
+		{forms: []string{"a", "b", "c"}, startLine: 3, endLine: 5},
+		{forms: []string{"ab", "ac"}, startLine: 7, endLine: 8},
+		{forms: []string{"a", "a", "a", "a"}, startLine: 10, endLine: 13},
+		{forms: []string{"b"}, startLine: 15, endLine: 15},
 	}) {
 		t.Log("Error, got unexpected form collection", response)
 		t.Fail()
@@ -174,13 +185,13 @@ func TestCollectForms(t *testing.T) {
 		"",
 		"",
 	})
-	if !reflect.DeepEqual(response, map[int][]string{}) {
+	if response != nil {
 		t.Log("Error, expected empty form collection, got", response)
 		t.Fail()
 	}
 
 	response = collectForms([]string{})
-	if !reflect.DeepEqual(response, map[int][]string{}) {
+	if response != nil {
 		t.Log("Error, expected empty form collection, got", response)
 		t.Fail()
 	}
@@ -188,13 +199,7 @@ func TestCollectForms(t *testing.T) {
 
 func TestCountAnswerd(t *testing.T) {
 	var response int
-	response = countAnswered(map[int][]string{
-		0: {"abc"},
-		1: {"a", "b", "c"},
-		2: {"ab", "ac"},
-		3: {"a", "a", "a", "a"},
-		4: {"b"},
-	})
+	response = countAnswered(testForms)
 	if response != 11 {
 		t.Log("Expected 11, got", response)
 		t.Fail()
@@ -203,15 +208,193 @@ func TestCountAnswerd(t *testing.T) {
 
 func TestCountEveryoneAnswerd(t *testing.T) {
 	var response int
-	response = countEveryoneAnswered(map[int][]string{
-		0: {"abc"},
-		1: {"a", "b", "c"},
-		2: {"ab", "ac"},
-		3: {"a", "a", "a", "a"},
-		4: {"b"},
-	})
+	response = countEveryoneAnswered(testForms)
 	if response != 6 {
 		t.Log("Expected 6, got", response)
 		t.Fail()
 	}
 }
+
+func TestCountAnswers(t *testing.T) {
+	response := countAnswers([]string{"ab", "ac", ""})
+	if !reflect.DeepEqual(response, map[string]int{"a": 2, "b": 1, "c": 1}) {
+		t.Log("Error, unexpected answer counts", response)
+		t.Fail()
+	}
+}
+
+func TestParseQuantifier(t *testing.T) {
+	q, err := parseQuantifier("atleast:2")
+	if err != nil || q.kind != "atleast" || q.count != 2 {
+		t.Log("Error, unexpected quantifier", q, err)
+		t.Fail()
+	}
+	q, err = parseQuantifier("fraction:2/3")
+	if err != nil || q.kind != "fraction" || q.fraction.Cmp(big.NewRat(2, 3)) != 0 {
+		t.Log("Error, unexpected quantifier", q, err)
+		t.Fail()
+	}
+
+	for _, bad := range []string{"", "atleast", "atleast:x", "exactly:-1", "fraction:1.5", "fraction:x", "some:1"} {
+		if q, err = parseQuantifier(bad); err == nil {
+			t.Log("Expected error parsing query", bad, "got", q)
+			t.Fail()
+		}
+	}
+}
+
+func TestCountQuery(t *testing.T) {
+	for spec, expected := range map[string]int{
+		"atleast:1":    11,
+		"atleast:2":    2,
+		"atmost:1":     9,
+		"exactly:4":    1,
+		"fraction:1":   6,
+		"fraction:0.5": 8,
+		"fraction:1/3": 11,
+	} {
+		q, err := parseQuantifier(spec)
+		if err != nil {
+			t.Log("Unexpected error parsing", spec, err)
+			t.Fail()
+			continue
+		}
+		if response := countQuery(testForms, q); response != expected {
+			t.Log("Expected", expected, "for", spec, "got", response)
+			t.Fail()
+		}
+	}
+}
+
+func TestAnswerHistogram(t *testing.T) {
+	histogram := answerHistogram(testForms)
+	expected := []questionFrequency{
+		{question: "a", people: 8, groups: 4},
+		{question: "b", people: 4, groups: 4},
+		{question: "c", people: 3, groups: 3},
+	}
+	if !reflect.DeepEqual(histogram, expected) {
+		t.Log("Error, unexpected histogram", histogram)
+		t.Fail()
+	}
+
+	lines := formatHistogram(histogram, 8)
+	if !reflect.DeepEqual(lines, []string{
+		"a     8     4 ########",
+		"b     4     4 ####",
+		"c     3     3 ###",
+	}) {
+		t.Log("Error, unexpected histogram lines", lines)
+		t.Fail()
+	}
+}
+
+func TestCollectFormsBlankLines(t *testing.T) {
+	response := collectForms([]string{"", "ab", "", "", "c", "d"})
+	expected := []group{
+		{forms: []string{"ab"}, startLine: 2, endLine: 2},
+		{forms: []string{"c", "d"}, startLine: 5, endLine: 6},
+	}
+	if !reflect.DeepEqual(response, expected) {
+		t.Log("Error, got unexpected form collection", response)
+		t.Fail()
+	}
+}
+
+func TestBreakdown(t *testing.T) {
+	reports := breakdown(testForms[1:3])
+	expected := []groupReport{
+		{Group: 1, StartLine: 3, EndLine: 5, Size: 3, Union: []string{"a", "b", "c"},
+			Intersection: []string{}, Anyone: 3, Everyone: 0},
+		{Group: 2, StartLine: 7, EndLine: 8, Size: 2, Union: []string{"a", "b", "c"},
+			Intersection: []string{"a"}, Anyone: 3, Everyone: 1},
+	}
+	if !reflect.DeepEqual(reports, expected) {
+		t.Log("Error, unexpected breakdown", reports)
+		t.Fail()
+	}
+
+	var buf bytes.Buffer
+	if err := writeBreakdown(&buf, reports, "text"); err != nil {
+		t.Log("Unexpected error:", err)
+		t.Fail()
+	}
+	if buf.String() != "group 1 (lines 3-5) size 3: anyone 3 [abc] everyone 0 []\n"+
+		"group 2 (lines 7-8) size 2: anyone 3 [abc] everyone 1 [a]\n" {
+		t.Log("Error, unexpected text breakdown", buf.String())
+		t.Fail()
+	}
+
+	buf.Reset()
+	if err := writeBreakdown(&buf, reports[1:], "json"); err != nil {
+		t.Log("Unexpected error:", err)
+		t.Fail()
+	}
+	if buf.String() != `{"group":2,"start_line":7,"end_line":8,"size":2,"union":["a","b","c"],`+
+		`"intersection":["a"],"anyone":3,"everyone":1}`+"\n" {
+		t.Log("Error, unexpected JSON breakdown", buf.String())
+		t.Fail()
+	}
+
+	if err := writeBreakdown(&buf, reports, "xml"); err == nil {
+		t.Log("Expected error for unknown format")
+		t.Fail()
+	}
+}
+
+func TestGenerateGroups(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		lines, part1, part2 := generateGroups(rand.New(rand.NewSource(seed)), 500)
+		groups := collectForms(lines)
+		if len(groups) != 500 {
+			t.Log("Seed", seed, "expected 500 groups, got", len(groups))
+			t.Fail()
+		}
+		if count := countAnswered(groups); count != part1 {
+			t.Log("Seed", seed, "expected", part1, "answered by anyone, got", count)
+			t.Fail()
+		}
+		if count := countEveryoneAnswered(groups); count != part2 {
+			t.Log("Seed", seed, "expected", part2, "answered by everyone, got", count)
+			t.Fail()
+		}
+	}
+}
+
+func FuzzCollectForms(f *testing.F) {
+	f.Add("abc\n\na\nb\nc\n\nab\nac\n\na\na\na\na\n\nb")
+	f.Add("\n\nab\n\n\nc\nd\n")
+	f.Fuzz(func(t *testing.T, input string) {
+		lines := strings.Split(input, "\n")
+		groups := collectForms(lines)
+		var forms, formLines int
+		for _, g := range groups {
+			forms += len(g.forms)
+			for _, form := range g.forms {
+				if form == "" {
+					t.Log("Error, empty form in group", g)
+					t.Fail()
+				}
+			}
+		}
+		for _, line := range lines {
+			if line != "" {
+				formLines++
+			}
+		}
+		if forms != formLines {
+			t.Log("Error, groups hold", forms, "of", formLines, "forms")
+			t.Fail()
+		}
+
+		if anyone, everyone := countAnswered(groups), countEveryoneAnswered(groups); everyone > anyone {
+			t.Log("Error, more answered by everyone than anyone", everyone, anyone)
+			t.Fail()
+		}
+		formatHistogram(answerHistogram(groups), 50)
+		if err := writeBreakdown(ioutil.Discard, breakdown(groups), "json"); err != nil {
+			t.Log("Unexpected error:", err)
+			t.Fail()
+		}
+	})
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"bufio"
+	"encoding/csv"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log/slog"
+	"math/rand"
 	"os"
 	"strconv"
 	"strings"
@@ -13,76 +18,441 @@ import (
 /* Errors should be printed to STDERR not STDOUT */
 func die(err error) {
 	fmt.Fprintf(os.Stderr, "%s\n", err)
+	closeTrace()
 	os.Exit(1)
 }
 
-func readFile(filename string) ([]string, error) {
-	data, err := ioutil.ReadFile(filename)
+var logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+var tracer *slog.Logger
+
+var closeTrace = func() {}
+
+func setupLogging(verbose bool, veryVerbose bool, traceFile string) (func(), error) {
+	level := slog.LevelWarn
+	if verbose {
+		level = slog.LevelInfo
+	}
+	if veryVerbose {
+		level = slog.LevelDebug
+	}
+	logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	if traceFile == "" {
+		return func() {}, nil
+	}
+	file, err := os.Create(traceFile)
 	if err != nil {
 		return nil, err
 	}
-	lines := strings.Split(string(data), "\n")
-	/* Split on an empty returns an array of length 1 */
-	if len(data) == 0 || string(data) == "\n" || len(lines) == 0 {
-		return nil, errors.New("Empty file")
+	buffered := bufio.NewWriter(file)
+	tracer = slog.New(slog.NewJSONHandler(buffered, nil))
+	return func() {
+		if err := buffered.Flush(); err != nil {
+			logger.Warn("Failed to write the trace", "file", traceFile, "err", err)
+		}
+		if err := file.Close(); err != nil {
+			logger.Warn("Failed to close the trace", "file", traceFile, "err", err)
+		}
+	}, nil
+}
+
+/* Calls found with each depth in turn, skipping blank lines */
+func readDepths(r io.Reader, found func(depth int)) error {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		depth, err := strconv.Atoi(text)
+		if err != nil {
+			return fmt.Errorf("Line %d: %s", line, err)
+		}
+		found(depth)
 	}
+	return scanner.Err()
+}
 
-	return lines, nil
+/*
+ * Compares each sum of window depths with the sum before it. Consecutive
+ * windows share all but one depth, so that's the same as comparing a depth
+ * with the one window places earlier, and only the last window depths need
+ * to be kept however long the sweep is.
+ */
+type windowCounter struct {
+	recent []int // ring buffer of the last window depths
+	seen   int
+	count  string
+	total  int
 }
 
-func comparePrevious(inputData []string) int {
-	var count = -1
-	var previous = 0
-	for _, line := range inputData {
-		num, _ := strconv.Atoi(line)
-		if num > previous {
-			count++
+func newWindowCounter(window int, count string) (*windowCounter, error) {
+	if window < 1 {
+		return nil, fmt.Errorf("Window must be at least 1, got %d", window)
+	}
+	switch count {
+	case "increase", "decrease", "equal":
+	default:
+		return nil, fmt.Errorf("Unknown count %q, expected increase, decrease or equal", count)
+	}
+	return &windowCounter{recent: make([]int, window), count: count}, nil
+}
+
+func (c *windowCounter) add(depth int) {
+	slot := c.seen % len(c.recent)
+	if c.seen >= len(c.recent) {
+		previous := c.recent[slot]
+		if (c.count == "increase" && depth > previous) ||
+			(c.count == "decrease" && depth < previous) ||
+			(c.count == "equal" && depth == previous) {
+			c.total++
 		}
-		previous = num
 	}
-	return count
+	if tracer != nil {
+		tracer.Info("depth", "index", c.seen, "depth", depth, "total", c.total)
+	}
+	c.recent[slot] = depth
+	c.seen++
 }
 
-func comparePreviousThree(inputData []string) int {
-	var count = 0
-	var sum = 0
-	var lastSum = 0
-	nums := []int{0, 0, 0}
-	for idx, line := range inputData {
-		num, _ := strconv.Atoi(line)
-		nums = nums[1:]
-		nums = append(nums, num)
-		sum = nums[0] + nums[1] + nums[2]
-		if idx < 3 {
-			lastSum = sum
-			continue
+func countWindowChanges(r io.Reader, window int, count string) (int, error) {
+	counter, err := newWindowCounter(window, count)
+	if err != nil {
+		return 0, err
+	}
+	if err := readDepths(r, counter.add); err != nil {
+		return 0, err
+	}
+	if counter.seen == 0 {
+		return 0, errors.New("No depths found")
+	}
+	logger.Info("Swept depths", "depths", counter.seen, "window", window)
+	return counter.total, nil
+}
+
+/*
+ * Holds every depth and sums each window in full before comparing it with the
+ * one before, to check the window counter against
+ */
+func countWindowChangesReference(r io.Reader, window int, count string) (int, error) {
+	if _, err := newWindowCounter(window, count); err != nil {
+		return 0, err
+	}
+	var depths []int
+	if err := readDepths(r, func(depth int) { depths = append(depths, depth) }); err != nil {
+		return 0, err
+	}
+	if len(depths) == 0 {
+		return 0, errors.New("No depths found")
+	}
+	var sums []int
+	for end := window; end <= len(depths); end++ {
+		sum := 0
+		for _, depth := range depths[end-window : end] {
+			sum += depth
 		}
-		if sum > lastSum {
-			count++
+		sums = append(sums, sum)
+	}
+	var total int
+	for i := 1; i < len(sums); i++ {
+		if (count == "increase" && sums[i] > sums[i-1]) ||
+			(count == "decrease" && sums[i] < sums[i-1]) ||
+			(count == "equal" && sums[i] == sums[i-1]) {
+			total++
 		}
-		lastSum = sum
 	}
-	return count
+	return total, nil
+}
+
+/* Keeps a running total of the last window depths */
+type windowSum struct {
+	recent []int
+	seen   int
+	sum    int
+}
+
+func newWindowSum(window int) *windowSum {
+	return &windowSum{recent: make([]int, window)}
+}
+
+/* Returns the sum of the window ending at depth, and whether the window is full yet */
+func (w *windowSum) add(depth int) (int, bool) {
+	slot := w.seen % len(w.recent)
+	w.sum += depth - w.recent[slot]
+	w.recent[slot] = depth
+	w.seen++
+	return w.sum, w.seen >= len(w.recent)
+}
+
+/* A stretch of depths, numbered from 1 in the order they were read */
+type depthRun struct {
+	start  int
+	length int
+	from   int
+	to     int
+}
+
+type depthProfile struct {
+	depths          int
+	previous        int
+	increasing      depthRun // the runs in progress
+	decreasing      depthRun
+	longestIncrease depthRun
+	longestDecrease depthRun
+	largestJump     depthRun // a run of two depths
+	window          *windowSum
+	windows         int
+	lowestStart     int // where the windows with the lowest and highest sums start
+	highestStart    int
+	lowestSum       int
+	highestSum      int
+}
+
+func newDepthProfile(window int) *depthProfile {
+	return &depthProfile{window: newWindowSum(window)}
+}
+
+func (p *depthProfile) add(depth int) {
+	p.depths++
+	if p.depths == 1 {
+		p.increasing = depthRun{1, 1, depth, depth}
+		p.decreasing = p.increasing
+	} else {
+		p.increasing = extendRun(p.increasing, p.depths, depth, depth > p.previous)
+		p.decreasing = extendRun(p.decreasing, p.depths, depth, depth < p.previous)
+		if p.largestJump.length == 0 || absolute(depth-p.previous) > absolute(p.largestJump.to-p.largestJump.from) {
+			p.largestJump = depthRun{p.depths - 1, 2, p.previous, depth}
+		}
+	}
+	if p.increasing.length > p.longestIncrease.length {
+		p.longestIncrease = p.increasing
+	}
+	if p.decreasing.length > p.longestDecrease.length {
+		p.longestDecrease = p.decreasing
+	}
+
+	if sum, full := p.window.add(depth); full {
+		start := p.depths - len(p.window.recent) + 1
+		p.windows++
+		if p.windows == 1 || sum < p.lowestSum {
+			p.lowestStart, p.lowestSum = start, sum
+		}
+		if p.windows == 1 || sum > p.highestSum {
+			p.highestStart, p.highestSum = start, sum
+		}
+	}
+	p.previous = depth
+}
+
+func extendRun(run depthRun, index int, depth int, continues bool) depthRun {
+	if continues {
+		run.length++
+		run.to = depth
+		return run
+	}
+	return depthRun{index, 1, depth, depth}
+}
+
+func absolute(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func (p *depthProfile) report() []string {
+	window := len(p.window.recent)
+	lines := []string{
+		fmt.Sprintf("depths: %d", p.depths),
+		fmt.Sprintf("longest increasing run: %d depths from #%d (%d to %d)",
+			p.longestIncrease.length, p.longestIncrease.start, p.longestIncrease.from, p.longestIncrease.to),
+		fmt.Sprintf("longest decreasing run: %d depths from #%d (%d to %d)",
+			p.longestDecrease.length, p.longestDecrease.start, p.longestDecrease.from, p.longestDecrease.to),
+	}
+	if p.largestJump.length != 0 {
+		lines = append(lines, fmt.Sprintf("largest jump: %+d at #%d (%d to %d)",
+			p.largestJump.to-p.largestJump.from, p.largestJump.start+1, p.largestJump.from, p.largestJump.to))
+	}
+	if p.windows != 0 {
+		lines = append(lines,
+			fmt.Sprintf("lowest moving average (window %d): %.2f from #%d",
+				window, float64(p.lowestSum)/float64(window), p.lowestStart),
+			fmt.Sprintf("highest moving average (window %d): %.2f from #%d",
+				window, float64(p.highestSum)/float64(window), p.highestStart))
+	}
+	return lines
+}
+
+func profileDepths(r io.Reader, window int) (*depthProfile, error) {
+	if window < 1 {
+		return nil, fmt.Errorf("Window must be at least 1, got %d", window)
+	}
+	profile := newDepthProfile(window)
+	if err := readDepths(r, profile.add); err != nil {
+		return nil, err
+	}
+	if profile.depths == 0 {
+		return nil, errors.New("No depths found")
+	}
+	return profile, nil
+}
+
+/* Window columns are left empty until the first window is full */
+func writeDepthCSV(r io.Reader, w io.Writer, window int) error {
+	if window < 1 {
+		return fmt.Errorf("Window must be at least 1, got %d", window)
+	}
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"index", "depth", "window_sum", "moving_average"}); err != nil {
+		return err
+	}
+	sums := newWindowSum(window)
+	var index int
+	var writeErr error
+	err := readDepths(r, func(depth int) {
+		index++
+		row := []string{strconv.Itoa(index), strconv.Itoa(depth), "", ""}
+		if sum, full := sums.add(depth); full {
+			row[2] = strconv.Itoa(sum)
+			row[3] = strconv.FormatFloat(float64(sum)/float64(window), 'f', 2, 64)
+		}
+		if writeErr == nil {
+			writeErr = writer.Write(row)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+/*
+ * Sonar depths drawn as a walk of random steps, counting as the steps are
+ * chosen: a depth increases when its step is positive, and a window of three
+ * increases when the last three steps add up to more than nothing.
+ */
+func generateDepths(random *rand.Rand, size int) ([]string, int, int) {
+	var lines []string
+	var steps []int
+	var increases, windowIncreases int
+	depth := 100 + random.Intn(100)
+	for i := 0; i < size; i++ {
+		if i > 0 {
+			step := random.Intn(41) - 15
+			if depth+step < 0 {
+				step = -step
+			}
+			depth += step
+			steps = append(steps, step)
+			if step > 0 {
+				increases++
+			}
+			if n := len(steps); n >= 3 && steps[n-1]+steps[n-2]+steps[n-3] > 0 {
+				windowIncreases++
+			}
+		}
+		lines = append(lines, strconv.Itoa(depth))
+	}
+	return lines, increases, windowIncreases
+}
+
+func writeGenerated(prefix string, lines []string, part1 int, part2 int) error {
+	if err := ioutil.WriteFile(prefix, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(prefix+".answers", []byte(fmt.Sprintf("%d\n%d\n", part1, part2)), 0644)
 }
 
 func main() {
 	var fileName string
+	var window int
+	var count string
+	var profile bool
+	var export bool
+	var generate string
+	var seed int64
+	var size int
+	var reference bool
 	var part2 bool
-	var result int
+	var verbose bool
+	var veryVerbose bool
+	var traceFile string
 
 	flag.StringVar(&fileName, "f", "input", "Input file")
+	flag.IntVar(&window, "window", 0, "Number of depths to sum in each window, 1 or 3 with -2 by default")
+	flag.StringVar(&count, "count", "increase", "Count windows that increase, decrease or are equal")
+	flag.BoolVar(&profile, "profile", false, "Report runs, jumps and moving averages instead of a count")
+	flag.BoolVar(&export, "csv", false, "Write the depths and window sums as CSV instead of a count")
+	flag.StringVar(&generate, "generate", "", "Write a random input to this file and its answers to the file plus .answers")
+	flag.Int64Var(&seed, "seed", 1, "Seed for -generate")
+	flag.IntVar(&size, "size", 2000, "Number of depths for -generate")
+	flag.BoolVar(&reference, "reference", false, "Sum every window in full, for cross checking")
+	flag.BoolVar(&verbose, "v", false, "Log progress to STDERR")
+	flag.BoolVar(&veryVerbose, "vv", false, "Log progress and details to STDERR")
+	flag.StringVar(&traceFile, "trace", "", "Write each depth and the running count to this file as JSON lines")
 	flag.BoolVar(&part2, "2", false, "Compute part 2 of the exercise")
 	flag.Parse()
 
-	inputData, err := readFile(fileName)
+	flush, err := setupLogging(verbose, veryVerbose, traceFile)
 	if err != nil {
 		die(err)
 	}
+	closeTrace = flush
+	defer closeTrace()
 
-	if !part2 {
-		result = comparePrevious(inputData)
-	} else {
-		result = comparePreviousThree(inputData)
+	if generate != "" {
+		lines, part1Answer, part2Answer := generateDepths(rand.New(rand.NewSource(seed)), size)
+		if err := writeGenerated(generate, lines, part1Answer, part2Answer); err != nil {
+			die(err)
+		}
+		return
+	}
+
+	if window == 0 {
+		window = 1
+		if part2 {
+			window = 3
+		}
+	}
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		die(err)
+	}
+	defer file.Close()
+
+	if export {
+		if err := writeDepthCSV(file, os.Stdout, window); err != nil {
+			die(err)
+		}
+		return
+	}
+
+	if profile {
+		p, err := profileDepths(file, window)
+		if err != nil {
+			die(err)
+		}
+		for _, line := range p.report() {
+			fmt.Println(line)
+		}
+		return
+	}
+
+	changes := countWindowChanges
+	if reference {
+		changes = countWindowChangesReference
+	}
+	logger.Debug("Counting window changes", "file", fileName, "window", window, "count", count, "reference", reference)
+	result, err := changes(file, window, count)
+	if err != nil {
+		die(err)
 	}
 
 	fmt.Println(result)
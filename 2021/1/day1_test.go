@@ -1,141 +1,228 @@
 package main
 
 import (
+	"bytes"
 	"io/ioutil"
-	"os"
+	"math/rand"
 	"reflect"
 	"strings"
 	"testing"
 )
 
-func createTestFile(contents []byte, t *testing.T) string {
-	tempFile, err := ioutil.TempFile("/tmp", "unit-test.*")
-	if err != nil {
-		t.Log("Failed to create temp file", err)
+func TestCountWindowChanges(t *testing.T) {
+	contents := "1\n5\n2\n3"
+	result, err := countWindowChanges(strings.NewReader(contents), 1, "increase")
+	if err != nil || result != 2 {
+		t.Log("Got wrong result, expected 2 instead of:", result, err)
+		t.Fail()
+	}
+}
+
+func TestCountWindowChangesThree(t *testing.T) {
+	contents := "199\n200\n208\n210\n200\n207\n240\n269\n260\n263\n"
+	result, err := countWindowChanges(strings.NewReader(contents), 3, "increase")
+	if err != nil || result != 5 {
+		t.Log("Got wrong result, expected 5 instead of:", result, err)
 		t.Fail()
 	}
+}
 
-	if len(contents) != 0 {
-		if _, err := tempFile.Write(contents); err != nil {
-			t.Log("Failed to write to file:", err)
+func TestCountWindowChangesModes(t *testing.T) {
+	contents := "3\n3\n1\n\n4\n4\n2\n"
+	for _, c := range []struct {
+		window   int
+		count    string
+		expected int
+	}{
+		{1, "increase", 1},
+		{1, "decrease", 2},
+		{1, "equal", 2},
+		// Sums 7, 8, 9, 10
+		{3, "increase", 3},
+		{3, "decrease", 0},
+		// Sums 11, 12, 11
+		{4, "increase", 1},
+		{4, "decrease", 1},
+		{4, "equal", 0},
+		{7, "increase", 0},
+	} {
+		result, err := countWindowChanges(strings.NewReader(contents), c.window, c.count)
+		if err != nil || result != c.expected {
+			t.Log("Window", c.window, c.count, "expected", c.expected, "got", result, err)
 			t.Fail()
 		}
 	}
+}
 
-	if err := tempFile.Close(); err != nil {
-		t.Log("Failed to write to file:", err)
+func TestCountWindowChangesErrors(t *testing.T) {
+	if _, err := countWindowChanges(strings.NewReader("1\nx\n"), 1, "increase"); err == nil {
+		t.Log("Expected error for a bad depth")
 		t.Fail()
 	}
-
-	return tempFile.Name()
-}
-
-func TestCreateTestFile(t *testing.T) {
-	contents := []byte("Line1\nLine2")
-	fileName := createTestFile(contents, t)
-	defer os.Remove(fileName)
-
-	readContents, err := readFile(fileName)
-	if err != nil {
-		t.Log("Failed to read file:", err)
+	if _, err := countWindowChanges(strings.NewReader("\n\n"), 1, "increase"); err == nil {
+		t.Log("Expected error for no depths")
 		t.Fail()
 	}
-
-	if !reflect.DeepEqual(strings.Split(string(contents), "\n"), readContents) {
-		t.Log("Error, contents of test file differs", readContents)
+	if _, err := countWindowChanges(strings.NewReader("1\n"), 0, "increase"); err == nil {
+		t.Log("Expected error for an empty window")
+		t.Fail()
+	}
+	if _, err := countWindowChanges(strings.NewReader("1\n"), 1, "more"); err == nil {
+		t.Log("Expected error for an unknown count")
 		t.Fail()
 	}
 }
 
-func TestReadFile(t *testing.T) {
-	contents := []byte("Line1\nLine2")
-	fileName := createTestFile(contents, t)
-	defer os.Remove(fileName)
+func TestWindowSum(t *testing.T) {
+	sums := newWindowSum(2)
+	for _, c := range []struct {
+		depth int
+		sum   int
+		full  bool
+	}{
+		{1, 1, false},
+		{5, 6, true},
+		{2, 7, true},
+		{3, 5, true},
+	} {
+		sum, full := sums.add(c.depth)
+		if sum != c.sum || full != c.full {
+			t.Log("Adding", c.depth, "expected", c.sum, c.full, "got", sum, full)
+			t.Fail()
+		}
+	}
+}
 
-	readContents, err := readFile(fileName)
+func TestProfileDepths(t *testing.T) {
+	contents := "10\n11\n12\n9\n30\n29\n28\n27\n28\n"
+	profile, err := profileDepths(strings.NewReader(contents), 2)
 	if err != nil {
-		t.Log("Failed to read file:", err)
+		t.Log("Unexpected error:", err)
+		t.FailNow()
+	}
+	if profile.depths != 9 {
+		t.Log("Expected 9 depths, got", profile.depths)
 		t.Fail()
 	}
-
-	if !reflect.DeepEqual(strings.Split(string(contents), "\n"), readContents) {
-		t.Log("Error, contents of test file differs", readContents)
+	if profile.longestIncrease != (depthRun{1, 3, 10, 12}) {
+		t.Log("Error, unexpected increasing run", profile.longestIncrease)
 		t.Fail()
 	}
-}
-
-func TestReadFileSingleLine(t *testing.T) {
-	contents := []byte("Line1")
-	fileName := createTestFile(contents, t)
-	defer os.Remove(fileName)
-
-	readContents, err := readFile(fileName)
-	if err != nil {
-		t.Log("Failed to read file:", err)
+	if profile.longestDecrease != (depthRun{5, 4, 30, 27}) {
+		t.Log("Error, unexpected decreasing run", profile.longestDecrease)
+		t.Fail()
+	}
+	if profile.largestJump != (depthRun{4, 2, 9, 30}) {
+		t.Log("Error, unexpected jump", profile.largestJump)
 		t.Fail()
 	}
 
-	if !reflect.DeepEqual(strings.Split(string(contents), "\n"), readContents) {
-		t.Log("Error, contents of test file differs", readContents)
+	expected := []string{
+		"depths: 9",
+		"longest increasing run: 3 depths from #1 (10 to 12)",
+		"longest decreasing run: 4 depths from #5 (30 to 27)",
+		"largest jump: +21 at #5 (9 to 30)",
+		"lowest moving average (window 2): 10.50 from #1",
+		"highest moving average (window 2): 29.50 from #5",
+	}
+	if report := profile.report(); !reflect.DeepEqual(report, expected) {
+		t.Log("Error, unexpected report", report)
 		t.Fail()
 	}
-}
 
-func TestReadFileNonexistent(t *testing.T) {
-	_, err := readFile("unit-test.does-not-exist")
-	if err == nil {
-		t.Log("Failed to error on non-existent file")
+	if _, err = profileDepths(strings.NewReader(""), 2); err == nil {
+		t.Log("Expected error for no depths")
 		t.Fail()
 	}
 }
 
-func TestReadFileEmpty(t *testing.T) {
-	tempFile, err := ioutil.TempFile("/tmp", "unit-test.*")
-	if err != nil {
-		t.Log("Failed to create temp file", err)
+func TestWriteDepthCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeDepthCSV(strings.NewReader("1\n2\n\n4\n"), &buf, 2); err != nil {
+		t.Log("Unexpected error:", err)
 		t.Fail()
 	}
-	defer os.Remove(tempFile.Name())
-
-	if err := tempFile.Close(); err != nil {
-		t.Log("Failed to write to file:", err)
+	expected := "index,depth,window_sum,moving_average\n" +
+		"1,1,,\n" +
+		"2,2,3,1.50\n" +
+		"3,4,6,3.00\n"
+	if buf.String() != expected {
+		t.Log("Error, unexpected CSV", buf.String())
 		t.Fail()
 	}
 
-	readContents, err := readFile(tempFile.Name())
-	if err == nil {
-		t.Log("Should error on empty file, got:", readContents)
+	if err := writeDepthCSV(strings.NewReader("1\nx\n"), &buf, 2); err == nil {
+		t.Log("Expected error for a bad depth")
 		t.Fail()
 	}
 }
 
-func TestReadFileSingleNewline(t *testing.T) {
-	contents := []byte("\n")
-	fileName := createTestFile(contents, t)
-	defer os.Remove(fileName)
-
-	readContents, err := readFile(fileName)
-	if err == nil {
-		t.Log("Got contents where there should be none:", readContents)
-		t.Fail()
+func TestGenerateDepths(t *testing.T) {
+	for _, size := range []int{1, 3, 4, 2000} {
+		lines, part1, part2 := generateDepths(rand.New(rand.NewSource(int64(size))), size)
+		input := strings.Join(lines, "\n")
+		if result, err := countWindowChanges(strings.NewReader(input), 1, "increase"); err != nil || result != part1 {
+			t.Log("Expected", part1, "increases in", size, "depths, got", result, err)
+			t.Fail()
+		}
+		if result, err := countWindowChanges(strings.NewReader(input), 3, "increase"); err != nil || result != part2 {
+			t.Log("Expected", part2, "window increases in", size, "depths, got", result, err)
+			t.Fail()
+		}
 	}
 }
 
-func TestComparePrevious(t *testing.T) {
-	contents := []string{"1", "5", "2", "3"}
-	result := comparePrevious(contents)
-	if result != 2 {
-		t.Log("Got wrong result, expected 2 instead of:", result)
-		t.Fail()
-	}
+func FuzzReadDepths(f *testing.F) {
+	f.Add("199\n200\n208\n210\n200\n207\n240\n269\n260\n263\n")
+	f.Add("1\n\n 2 \n-3\n")
+	f.Add("1\nx\n")
+	f.Fuzz(func(t *testing.T, input string) {
+		var depths int
+		if err := readDepths(strings.NewReader(input), func(int) { depths++ }); err != nil {
+			return
+		}
+		for _, window := range []int{1, 3} {
+			var total int
+			for _, count := range []string{"increase", "decrease", "equal"} {
+				changes, err := countWindowChanges(strings.NewReader(input), window, count)
+				if err != nil && depths > 0 {
+					t.Log("Unexpected error counting", count, err)
+					t.Fail()
+				}
+				total += changes
+			}
+			if depths > window && total != depths-window {
+				t.Log("Error,", total, "changes between windows of", window, "in", depths, "depths")
+				t.Fail()
+			}
+			if depths > 0 {
+				if _, err := profileDepths(strings.NewReader(input), window); err != nil {
+					t.Log("Unexpected error profiling:", err)
+					t.Fail()
+				}
+			}
+			if err := writeDepthCSV(strings.NewReader(input), ioutil.Discard, window); err != nil && depths > 0 {
+				t.Log("Unexpected error writing CSV:", err)
+				t.Fail()
+			}
+		}
+	})
 }
 
-func TestComparePreviousThree(t *testing.T) {
-	contents := []string{"199", "200", "208", "210", "200", "207", "240", "269",
-		"260", "263"}
-	result := comparePreviousThree(contents)
-	if result != 5 {
-		t.Log("Got wrong result, expected 5 instead of:", result)
+func TestCountWindowChangesReference(t *testing.T) {
+	input := "199\n200\n208\n210\n200\n207\n240\n269\n260\n263\n"
+	for _, window := range []int{1, 2, 3, 4} {
+		for _, count := range []string{"increase", "decrease", "equal"} {
+			expected, err := countWindowChanges(strings.NewReader(input), window, count)
+			result, referenceErr := countWindowChangesReference(strings.NewReader(input), window, count)
+			if err != nil || referenceErr != nil || result != expected {
+				t.Log("Window", window, count, "expected", expected, err, "got", result, referenceErr)
+				t.Fail()
+			}
+		}
+	}
+	if _, err := countWindowChangesReference(strings.NewReader(""), 1, "increase"); err == nil {
+		t.Log("Expected error for no depths")
 		t.Fail()
 	}
 }
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log/slog"
+	"math/rand"
 	"os"
 	"strconv"
 	"strings"
@@ -13,9 +19,44 @@ import (
 /* Errors should be printed to STDERR not STDOUT */
 func die(err error) {
 	fmt.Fprintf(os.Stderr, "%s\n", err)
+	closeTrace()
 	os.Exit(1)
 }
 
+var logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+var tracer *slog.Logger
+
+var closeTrace = func() {}
+
+func setupLogging(verbose bool, veryVerbose bool, traceFile string) (func(), error) {
+	level := slog.LevelWarn
+	if verbose {
+		level = slog.LevelInfo
+	}
+	if veryVerbose {
+		level = slog.LevelDebug
+	}
+	logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	if traceFile == "" {
+		return func() {}, nil
+	}
+	file, err := os.Create(traceFile)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewWriter(file)
+	tracer = slog.New(slog.NewJSONHandler(buffered, nil))
+	return func() {
+		if err := buffered.Flush(); err != nil {
+			logger.Warn("Failed to write the trace", "file", traceFile, "err", err)
+		}
+		if err := file.Close(); err != nil {
+			logger.Warn("Failed to close the trace", "file", traceFile, "err", err)
+		}
+	}, nil
+}
+
 func readFile(filename string) ([]string, error) {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
@@ -30,78 +71,338 @@ func readFile(filename string) ([]string, error) {
 	return lines, nil
 }
 
-func calculateDistance(inputData []string) int {
-	horizontal := 0
-	depth := 0
-	for _, line := range inputData {
-		if len(line) == 0 {
-			continue
+type submarine struct {
+	horizontal int
+	depth      int
+	aim        int
+}
+
+/* A command changes the submarine's state, using the number after it if it takes one */
+type command struct {
+	takesAmount bool
+	apply       func(sub *submarine, amount int)
+}
+
+/* A movement model is the set of commands it understands */
+type model map[string]command
+
+/* Commands every model understands on top of its own */
+var commonCommands = model{
+	"back":  {true, func(sub *submarine, amount int) { sub.horizontal -= amount }},
+	"reset": {false, func(sub *submarine, amount int) { *sub = submarine{} }},
+}
+
+var models = map[string]model{
+	"simple": withCommonCommands(model{
+		"forward": {true, func(sub *submarine, amount int) { sub.horizontal += amount }},
+		"up":      {true, func(sub *submarine, amount int) { sub.depth -= amount }},
+		"down":    {true, func(sub *submarine, amount int) { sub.depth += amount }},
+	}),
+	"aimed": withCommonCommands(model{
+		"forward": {true, func(sub *submarine, amount int) {
+			sub.horizontal += amount
+			sub.depth += sub.aim * amount
+		}},
+		"up":   {true, func(sub *submarine, amount int) { sub.aim -= amount }},
+		"down": {true, func(sub *submarine, amount int) { sub.aim += amount }},
+	}),
+}
+
+func withCommonCommands(m model) model {
+	for verb, c := range commonCommands {
+		if _, ok := m[verb]; !ok {
+			m[verb] = c
 		}
-		directions := strings.Split(line, " ")
-		if len(directions) == 0 {
+	}
+	return m
+}
+
+func (m model) execute(sub *submarine, line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	c, ok := m[fields[0]]
+	if !ok {
+		return fmt.Errorf("Unknown command %q", fields[0])
+	}
+	if !c.takesAmount {
+		if len(fields) != 1 {
+			return fmt.Errorf("Command %q takes no amount", fields[0])
+		}
+		c.apply(sub, 0)
+		return nil
+	}
+	if len(fields) != 2 {
+		return fmt.Errorf("Command %q takes one amount", fields[0])
+	}
+	amount, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return fmt.Errorf("Command %q: %s", fields[0], err)
+	}
+	c.apply(sub, amount)
+	return nil
+}
+
+/*
+ * Blank lines are skipped, anything else has to be a command the model knows.
+ * If given, record is called with the state after each command.
+ */
+func run(inputData []string, m model, record func(step int, line string, sub submarine)) (submarine, error) {
+	var sub submarine
+	var step int
+	for i, line := range inputData {
+		if strings.TrimSpace(line) == "" {
 			continue
 		}
-		direction := directions[0]
-		distance, _ := strconv.Atoi(directions[1])
-		switch direction {
-		case "forward":
-			horizontal += distance
-		case "up":
-			depth -= distance
-		case "down":
-			depth += distance
+		if err := m.execute(&sub, line); err != nil {
+			return sub, fmt.Errorf("Line %d: %s", i+1, err)
+		}
+		step++
+		if record != nil {
+			record(step, line, sub)
 		}
 	}
+	return sub, nil
+}
 
-	return horizontal * depth
+/* A record for run that writes each step to the trace */
+func traceStep(step int, line string, sub submarine) {
+	tracer.Info("step", "step", step, "command", strings.TrimSpace(line), "horizontal", sub.horizontal, "depth", sub.depth, "aim", sub.aim)
 }
 
-func calculateAimedDistance(inputData []string) int {
-	horizontal := 0
-	aim := 0
-	depth := 0
-	for _, line := range inputData {
-		if len(line) == 0 {
-			continue
+type trajectoryPoint struct {
+	Step       int    `json:"step"`
+	Command    string `json:"command"`
+	Horizontal int    `json:"horizontal"`
+	Depth      int    `json:"depth"`
+	Aim        int    `json:"aim"`
+}
+
+/* Starts with the submarine at the surface as step 0 */
+func trajectory(inputData []string, m model) ([]trajectoryPoint, error) {
+	points := []trajectoryPoint{{}}
+	_, err := run(inputData, m, func(step int, line string, sub submarine) {
+		points = append(points, trajectoryPoint{step, strings.TrimSpace(line), sub.horizontal, sub.depth, sub.aim})
+	})
+	return points, err
+}
+
+func writeTrajectory(w io.Writer, points []trajectoryPoint, format string) error {
+	switch format {
+	case "csv":
+		writer := csv.NewWriter(w)
+		if err := writer.Write([]string{"step", "command", "horizontal", "depth", "aim"}); err != nil {
+			return err
 		}
-		directions := strings.Split(line, " ")
-		if len(directions) == 0 {
-			continue
+		for _, p := range points {
+			row := []string{strconv.Itoa(p.Step), p.Command, strconv.Itoa(p.Horizontal), strconv.Itoa(p.Depth), strconv.Itoa(p.Aim)}
+			if err := writer.Write(row); err != nil {
+				return err
+			}
 		}
-		direction := directions[0]
-		distance, _ := strconv.Atoi(directions[1])
-		switch direction {
+		writer.Flush()
+		return writer.Error()
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(points)
+	}
+	return fmt.Errorf("Unknown trajectory format %q, expected csv or json", format)
+}
+
+const (
+	plotWidth       = 800
+	plotPanelHeight = 300
+	plotMargin      = 40
+)
+
+/*
+ * Draws one panel per model with horizontal position across and depth down,
+ * each scaled to fit its own panel as the aimed model goes far deeper
+ */
+func writeDepthSVG(w io.Writer, names []string, paths [][]trajectoryPoint) error {
+	bw := bufio.NewWriter(w)
+	height := len(paths) * (plotPanelHeight + plotMargin)
+	fmt.Fprintf(bw, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\">\n",
+		plotWidth+2*plotMargin, height+plotMargin)
+	fmt.Fprintln(bw, `<rect width="100%" height="100%" fill="white"/>`)
+	for i, points := range paths {
+		maxHorizontal, minDepth, maxDepth := 1, 0, 1
+		for _, p := range points {
+			if p.Horizontal > maxHorizontal {
+				maxHorizontal = p.Horizontal
+			}
+			if p.Depth < minDepth {
+				minDepth = p.Depth
+			}
+			if p.Depth > maxDepth {
+				maxDepth = p.Depth
+			}
+		}
+		top := plotMargin + i*(plotPanelHeight+plotMargin)
+		fmt.Fprintf(bw, "<text x=\"%d\" y=\"%d\" font-family=\"sans-serif\" font-size=\"14\">%s: horizontal 0-%d, depth %d-%d</text>\n",
+			plotMargin, top-8, names[i], maxHorizontal, minDepth, maxDepth)
+		fmt.Fprintf(bw, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"none\" stroke=\"gray\"/>\n",
+			plotMargin, top, plotWidth, plotPanelHeight)
+		fmt.Fprint(bw, `<polyline fill="none" stroke="navy" points="`)
+		for j, p := range points {
+			x := plotMargin + float64(p.Horizontal)*plotWidth/float64(maxHorizontal)
+			y := float64(top) + float64(p.Depth-minDepth)*plotPanelHeight/float64(maxDepth-minDepth)
+			if j > 0 {
+				fmt.Fprint(bw, " ")
+			}
+			fmt.Fprintf(bw, "%.1f,%.1f", x, y)
+		}
+		fmt.Fprintln(bw, `"/>`)
+	}
+	fmt.Fprintln(bw, "</svg>")
+	return bw.Flush()
+}
+
+func writeDepthSVGFile(fileName string, inputData []string) error {
+	var names []string
+	var paths [][]trajectoryPoint
+	for _, name := range []string{"simple", "aimed"} {
+		points, err := trajectory(inputData, models[name])
+		if err != nil {
+			return err
+		}
+		names = append(names, name)
+		paths = append(paths, points)
+	}
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := writeDepthSVG(file, names, paths); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+/*
+ * Commands only use forward, down and up, as the puzzle does. Going up never
+ * takes the submarine above the surface. Part 1's depth is the aim part 2
+ * steers by, so both answers come from the same tallies.
+ */
+func generateCommands(random *rand.Rand, size int) ([]string, int, int) {
+	var lines []string
+	var horizontal, aim, aimedDepth int
+	for i := 0; i < size; i++ {
+		amount := 1 + random.Intn(9)
+		verb := []string{"forward", "down", "up"}[random.Intn(3)]
+		if verb == "up" && aim < amount {
+			verb = "down"
+		}
+		switch verb {
 		case "forward":
-			horizontal += distance
-			depth += aim * distance
-		case "up":
-			aim -= distance
+			horizontal += amount
+			aimedDepth += aim * amount
 		case "down":
-			aim += distance
+			aim += amount
+		case "up":
+			aim -= amount
 		}
+		lines = append(lines, fmt.Sprintf("%s %d", verb, amount))
 	}
-	return horizontal * depth
+	return lines, horizontal * aim, horizontal * aimedDepth
+}
+
+func writeGenerated(prefix string, lines []string, part1 int, part2 int) error {
+	if err := ioutil.WriteFile(prefix, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(prefix+".answers", []byte(fmt.Sprintf("%d\n%d\n", part1, part2)), 0644)
 }
 
 func main() {
 	var fileName string
+	var modelName string
+	var export string
+	var plot string
+	var generate string
+	var seed int64
+	var size int
 	var part2 bool
-	var result int
+	var verbose bool
+	var veryVerbose bool
+	var traceFile string
 
 	flag.StringVar(&fileName, "f", "input", "Input file")
+	flag.StringVar(&modelName, "model", "", "Movement model, simple or aimed with -2 by default")
+	flag.StringVar(&export, "trajectory", "", "Write the state after every command as csv or json instead of the result")
+	flag.StringVar(&plot, "svg", "", "Draw the depth profile of both models to this SVG file instead of the result")
+	flag.StringVar(&generate, "generate", "", "Write a random input to this file and its answers to the file plus .answers")
+	flag.Int64Var(&seed, "seed", 1, "Seed for -generate")
+	flag.IntVar(&size, "size", 1000, "Number of commands for -generate")
+	flag.BoolVar(&verbose, "v", false, "Log progress to STDERR")
+	flag.BoolVar(&veryVerbose, "vv", false, "Log progress and details to STDERR")
+	flag.StringVar(&traceFile, "trace", "", "Write the submarine's state after each step to this file as JSON lines")
 	flag.BoolVar(&part2, "2", false, "Compute part 2 of the exercise")
 	flag.Parse()
 
+	flush, err := setupLogging(verbose, veryVerbose, traceFile)
+	if err != nil {
+		die(err)
+	}
+	closeTrace = flush
+	defer closeTrace()
+
+	if generate != "" {
+		lines, part1Answer, part2Answer := generateCommands(rand.New(rand.NewSource(seed)), size)
+		if err := writeGenerated(generate, lines, part1Answer, part2Answer); err != nil {
+			die(err)
+		}
+		return
+	}
+
+	if modelName == "" {
+		modelName = "simple"
+		if part2 {
+			modelName = "aimed"
+		}
+	}
+	m, ok := models[modelName]
+	if !ok {
+		die(fmt.Errorf("Unknown model %q", modelName))
+	}
+
 	inputData, err := readFile(fileName)
 	if err != nil {
 		die(err)
 	}
+	logger.Info("Read commands", "file", fileName, "lines", len(inputData), "model", modelName)
+
+	if plot != "" {
+		if err := writeDepthSVGFile(plot, inputData); err != nil {
+			die(err)
+		}
+		return
+	}
 
-	if !part2 {
-		result = calculateDistance(inputData)
-	} else {
-		result = calculateAimedDistance(inputData)
+	if export != "" {
+		points, err := trajectory(inputData, m)
+		if err != nil {
+			die(err)
+		}
+		if err := writeTrajectory(os.Stdout, points, export); err != nil {
+			die(err)
+		}
+		return
+	}
+
+	var record func(step int, line string, sub submarine)
+	if tracer != nil {
+		record = traceStep
+	}
+	sub, err := run(inputData, m, record)
+	if err != nil {
+		die(err)
 	}
+	logger.Debug("Final position", "horizontal", sub.horizontal, "depth", sub.depth, "aim", sub.aim)
 
-	fmt.Println(result)
+	fmt.Println(sub.horizontal * sub.depth)
 }
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"io/ioutil"
+	"log/slog"
+	"math/rand"
 	"os"
 	"reflect"
 	"strings"
@@ -121,22 +125,179 @@ func TestReadFileSingleNewline(t *testing.T) {
 	}
 }
 
-func TestCalculateDistance(t *testing.T) {
+func TestRunModels(t *testing.T) {
 	contents := []string{"forward 5", "down 5", "forward 8", "up 3", "down 8",
 		"forward 2"}
-	result := calculateDistance(contents)
-	if result != 150 {
-		t.Log("Got wrong result, expected 150 instead of:", result)
+	for name, expected := range map[string]int{"simple": 150, "aimed": 900} {
+		sub, err := run(contents, models[name], nil)
+		if err != nil || sub.horizontal*sub.depth != expected {
+			t.Log("Model", name, "got wrong result, expected", expected, "instead of:", sub.horizontal*sub.depth, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestRunCommonCommands(t *testing.T) {
+	contents := []string{"forward 5", "down 5", "reset", "forward 8", "back 3", "", "down 2"}
+	for name, expected := range map[string]submarine{
+		"simple": {horizontal: 5, depth: 2},
+		"aimed":  {horizontal: 5, aim: 2},
+	} {
+		sub, err := run(contents, models[name], nil)
+		if err != nil || sub != expected {
+			t.Log("Model", name, "expected", expected, "got", sub, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	for _, line := range []string{"sideways 5", "forward", "forward x", "forward 1 2", "reset 1", "up 5m"} {
+		if sub, err := run([]string{"forward 1", line}, models["simple"], nil); err == nil {
+			t.Log("Expected error running", line, "got", sub)
+			t.Fail()
+		} else if !strings.HasPrefix(err.Error(), "Line 2: ") {
+			t.Log("Expected the error to give the line, got", err)
+			t.Fail()
+		}
+	}
+}
+
+func TestTrajectory(t *testing.T) {
+	contents := []string{"forward 5", "", "down 5", "forward 8"}
+	points, err := trajectory(contents, models["aimed"])
+	if err != nil {
+		t.Log("Unexpected error:", err)
+		t.Fail()
+	}
+	expected := []trajectoryPoint{
+		{0, "", 0, 0, 0},
+		{1, "forward 5", 5, 0, 0},
+		{2, "down 5", 5, 0, 5},
+		{3, "forward 8", 13, 40, 5},
+	}
+	if !reflect.DeepEqual(points, expected) {
+		t.Log("Error, unexpected trajectory", points)
+		t.Fail()
+	}
+
+	var buf bytes.Buffer
+	if err := writeTrajectory(&buf, points[:2], "csv"); err != nil {
+		t.Log("Unexpected error:", err)
+		t.Fail()
+	}
+	if buf.String() != "step,command,horizontal,depth,aim\n0,,0,0,0\n1,forward 5,5,0,0\n" {
+		t.Log("Error, unexpected CSV", buf.String())
+		t.Fail()
+	}
+
+	buf.Reset()
+	if err := writeTrajectory(&buf, points[1:2], "json"); err != nil {
+		t.Log("Unexpected error:", err)
+		t.Fail()
+	}
+	var decoded []trajectoryPoint
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || !reflect.DeepEqual(decoded, points[1:2]) {
+		t.Log("Error, JSON didn't round trip", buf.String(), err)
+		t.Fail()
+	}
+
+	if err := writeTrajectory(&buf, points, "xml"); err == nil {
+		t.Log("Expected error for unknown format")
 		t.Fail()
 	}
 }
 
-func TestCalculateAimedDistance(t *testing.T) {
-	contents := []string{"forward 5", "down 5", "forward 8", "up 3", "down 8",
-		"forward 2"}
-	result := calculateAimedDistance(contents)
-	if result != 900 {
-		t.Log("Got wrong result, expected 900 instead of:", result)
+func TestWriteDepthSVG(t *testing.T) {
+	path := []trajectoryPoint{{0, "", 0, 0, 0}, {1, "forward 2", 2, 0, 0}, {2, "down 4", 2, 4, 0}}
+	var buf bytes.Buffer
+	if err := writeDepthSVG(&buf, []string{"simple", "aimed"}, [][]trajectoryPoint{path, path}); err != nil {
+		t.Log("Unexpected error:", err)
+		t.Fail()
+	}
+	svg := buf.String()
+	if strings.Count(svg, "<polyline") != 2 {
+		t.Log("Error, expected a line for each model", svg)
+		t.Fail()
+	}
+	if !strings.Contains(svg, `points="40.0,40.0 840.0,40.0 840.0,340.0"`) {
+		t.Log("Error, expected the first path to fill its panel", svg)
+		t.Fail()
+	}
+}
+
+func TestGenerateCommands(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		lines, part1, part2 := generateCommands(rand.New(rand.NewSource(seed)), 1000)
+		for name, expected := range map[string]int{"simple": part1, "aimed": part2} {
+			sub, err := run(lines, models[name], nil)
+			if err != nil || sub.horizontal*sub.depth != expected {
+				t.Log("Seed", seed, "model", name, "expected", expected, "got", sub.horizontal*sub.depth, err)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func FuzzRun(f *testing.F) {
+	f.Add("forward 5\ndown 5\nforward 8\nup 3\ndown 8\nforward 2\n")
+	f.Add("forward\nback 3\nreset\n\n  up  -4 ")
+	f.Add("down 1 2\nsideways 3")
+	f.Fuzz(func(t *testing.T, input string) {
+		lines := strings.Split(input, "\n")
+		var commands int
+		for _, line := range lines {
+			if strings.TrimSpace(line) != "" {
+				commands++
+			}
+		}
+		var names []string
+		var paths [][]trajectoryPoint
+		for _, name := range []string{"simple", "aimed"} {
+			points, err := trajectory(lines, models[name])
+			if err != nil {
+				continue
+			}
+			if len(points) != commands+1 {
+				t.Log("Error,", len(points), "points for", commands, "commands with the", name, "model")
+				t.Fail()
+			}
+			if err := writeTrajectory(ioutil.Discard, points, "csv"); err != nil {
+				t.Log("Unexpected error:", err)
+				t.Fail()
+			}
+			names = append(names, name)
+			paths = append(paths, points)
+		}
+		if err := writeDepthSVG(ioutil.Discard, names, paths); err != nil {
+			t.Log("Unexpected error:", err)
+			t.Fail()
+		}
+	})
+}
+
+func TestTraceStep(t *testing.T) {
+	var trace bytes.Buffer
+	tracer = slog.New(slog.NewJSONHandler(&trace, nil))
+	defer func() { tracer = nil }()
+
+	inputData := []string{"forward 5", "down 5", "", "forward 8", "up 3"}
+	if _, err := run(inputData, models["aimed"], traceStep); err != nil {
+		t.Log("Failed to run:", err)
+		t.FailNow()
+	}
+	var traced []trajectoryPoint
+	for _, line := range strings.Split(strings.TrimSpace(trace.String()), "\n") {
+		var p trajectoryPoint
+		if err := json.Unmarshal([]byte(line), &p); err != nil {
+			t.Log("Error, bad trace line", line, err)
+			t.FailNow()
+		}
+		traced = append(traced, p)
+	}
+	points, _ := trajectory(inputData, models["aimed"])
+	if !reflect.DeepEqual(traced, points[1:]) {
+		t.Log("Error, traced", traced, "but the trajectory is", points[1:])
 		t.Fail()
 	}
 }
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"bufio"
+	"container/heap"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log/slog"
+	"math/rand"
 	"os"
 	"sort"
 	"strconv"
@@ -14,95 +19,368 @@ import (
 /* Errors should be printed to STDERR not STDOUT */
 func die(err error) {
 	fmt.Fprintf(os.Stderr, "%s\n", err)
+	closeTrace()
 	os.Exit(1)
 }
 
-func readFile(filename string) ([]string, error) {
-	data, err := ioutil.ReadFile(filename)
+var logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+var tracer *slog.Logger
+
+var closeTrace = func() {}
+
+func setupLogging(verbose bool, veryVerbose bool, traceFile string) (func(), error) {
+	level := slog.LevelWarn
+	if verbose {
+		level = slog.LevelInfo
+	}
+	if veryVerbose {
+		level = slog.LevelDebug
+	}
+	logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	if traceFile == "" {
+		return func() {}, nil
+	}
+	file, err := os.Create(traceFile)
 	if err != nil {
 		return nil, err
 	}
-	lines := strings.Split(string(data), "\n")
-	/* Split on an empty returns an array of length 1 */
-	if len(data) == 0 || string(data) == "\n" || len(lines) == 0 {
-		return nil, errors.New("Empty file")
+	buffered := bufio.NewWriter(file)
+	tracer = slog.New(slog.NewJSONHandler(buffered, nil))
+	return func() {
+		if err := buffered.Flush(); err != nil {
+			logger.Warn("Failed to write the trace", "file", traceFile, "err", err)
+		}
+		if err := file.Close(); err != nil {
+			logger.Warn("Failed to close the trace", "file", traceFile, "err", err)
+		}
+	}, nil
+}
+
+type elf struct {
+	index    int // from 1, in input order
+	items    int
+	calories int
+}
+
+/*
+ * Totals each elf's items as the lines go past, so only the elf being read is
+ * held. A blank line ends an elf, as does the end of the input; runs of blank
+ * lines don't make empty elves.
+ */
+type elfAggregator struct {
+	current elf
+	elves   int
+	found   func(e elf)
+}
+
+func (a *elfAggregator) add(load int) {
+	a.current.items++
+	a.current.calories += load
+}
+
+func (a *elfAggregator) finish() {
+	if a.current.items == 0 {
+		return
 	}
+	a.elves++
+	a.current.index = a.elves
+	a.found(a.current)
+	a.current = elf{}
+}
 
-	return lines, nil
+/* strconv.Atoi without turning the line into a string, which adds up over gigabytes */
+func parseLoad(line []byte) (int, error) {
+	digits := line
+	if len(digits) > 0 && (digits[0] == '+' || digits[0] == '-') {
+		digits = digits[1:]
+	}
+	if len(digits) == 0 || len(digits) > 18 {
+		return strconv.Atoi(string(line))
+	}
+	var load int
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return strconv.Atoi(string(line))
+		}
+		load = load*10 + int(c-'0')
+	}
+	if line[0] == '-' {
+		load = -load
+	}
+	return load, nil
 }
 
-func calculateLoads(food []string) ([]int, error) {
-	var loads []int
-	var currentLoad int
-	hasData := false
-	for _, item := range food {
-		if len(item) > 0 {
-			hasData = true
-			load, err := strconv.Atoi(item)
-			if nil != err {
-				return nil, err
-			}
-			currentLoad += load
-		} else {
-			loads = append(loads, currentLoad)
-			currentLoad = 0
+func readElves(r io.Reader, found func(e elf)) error {
+	aggregator := elfAggregator{found: found}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			aggregator.finish()
+			continue
+		}
+		load, err := parseLoad(line)
+		if nil != err {
+			return err
 		}
+		aggregator.add(load)
 	}
-	if len(loads) < 1 || !hasData {
-		return nil, errors.New("Unable to find any loads")
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	aggregator.finish()
+	if aggregator.elves < 1 {
+		return errors.New("Unable to find any loads")
+	}
+	return nil
+}
+
+/*
+ * A min-heap of the heaviest elves seen so far, so the lightest of them is the
+ * one to drop when a heavier elf turns up. On a tie the later elf is dropped.
+ */
+type elfHeap []elf
+
+func lighter(a elf, b elf) bool {
+	if a.calories != b.calories {
+		return a.calories < b.calories
+	}
+	return a.index > b.index
+}
+
+func (h elfHeap) Len() int            { return len(h) }
+func (h elfHeap) Less(i, j int) bool  { return lighter(h[i], h[j]) }
+func (h elfHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *elfHeap) Push(x interface{}) { *h = append(*h, x.(elf)) }
+func (h *elfHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+/* Keeps at most n elves however many are offered */
+type topN struct {
+	n     int
+	elves elfHeap
+}
+
+func newTopN(n int) *topN {
+	return &topN{n: n}
+}
+
+func (t *topN) offer(e elf) {
+	if len(t.elves) < t.n {
+		heap.Push(&t.elves, e)
+	} else if t.n > 0 && lighter(t.elves[0], e) {
+		t.elves[0] = e
+		heap.Fix(&t.elves, 0)
 	}
-	return loads, nil
 }
 
-func findHeaviestLoad(food []int) int {
-	heaviest := 0
-	for _, load := range food {
-		if load > heaviest {
-			heaviest = load
+/* Heaviest first */
+func (t *topN) ranked() []elf {
+	ranked := make([]elf, len(t.elves))
+	copy(ranked, t.elves)
+	sort.Slice(ranked, func(i, j int) bool { return lighter(ranked[j], ranked[i]) })
+	return ranked
+}
+
+func findTopElves(elves []elf, n int) ([]elf, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("Need to find at least 1 elf, asked for %d", n)
+	}
+	if len(elves) < n {
+		return nil, fmt.Errorf("Not enough elves, need minimum of %d", n)
+	}
+	top := newTopN(n)
+	for _, e := range elves {
+		top.offer(e)
+	}
+	return top.ranked(), nil
+}
+
+/* Only the heaviest n elves are ever held, however long the input */
+func streamTopElves(r io.Reader, n int) ([]elf, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("Need to find at least 1 elf, asked for %d", n)
+	}
+	top := newTopN(n)
+	var count int
+	if err := readElves(r, func(e elf) {
+		count++
+		top.offer(e)
+		if tracer != nil {
+			tracer.Info("elf", "elf", e.index, "items", e.items, "calories", e.calories, "lightest_kept", top.elves[0].calories)
 		}
+	}); err != nil {
+		return nil, err
+	}
+	logger.Info("Read elves", "elves", count)
+	if count < n {
+		return nil, fmt.Errorf("Not enough elves, need minimum of %d", n)
 	}
-	return heaviest
+	return top.ranked(), nil
 }
 
-func findTopThreeTotal(food []int) (int, error) {
+func totalCalories(elves []elf) int {
 	total := 0
-	sort.Ints(food)
-	if len(food) < 3 {
-		return -1, errors.New("Not enough elves, need minimum of 3")
+	for _, e := range elves {
+		total += e.calories
 	}
-	lastThree := food[len(food)-3:]
-	for _, i := range lastThree {
-		total += i
+	return total
+}
+
+/* Sorts every load rather than keeping a heap, to check streamTopElves against */
+func findTopTotalReference(food []int, n int) (int, error) {
+	if n < 1 {
+		return -1, fmt.Errorf("Need to find at least 1 elf, asked for %d", n)
+	}
+	if len(food) < n {
+		return -1, fmt.Errorf("Not enough elves, need minimum of %d", n)
+	}
+	sorted := append([]int{}, food...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+	total := 0
+	for _, load := range sorted[:n] {
+		total += load
 	}
 	return total, nil
 }
 
+func rankingReport(ranked []elf) []string {
+	lines := []string{"rank elf items calories"}
+	for i, e := range ranked {
+		lines = append(lines, fmt.Sprintf("%d %d %d %d", i+1, e.index, e.items, e.calories))
+	}
+	return lines
+}
+
+/*
+ * Elves carry one to fifteen items each; the answers come from sorting their
+ * totals. There are always at least three, or part 2 has no answer.
+ */
+func generateElves(random *rand.Rand, size int) ([]string, int, int) {
+	if size < 3 {
+		size = 3
+	}
+	var lines []string
+	var totals []int
+	for i := 0; i < size; i++ {
+		if i > 0 {
+			lines = append(lines, "")
+		}
+		var total int
+		for items := 1 + random.Intn(15); items > 0; items-- {
+			calories := 1000 + random.Intn(59000)
+			lines = append(lines, strconv.Itoa(calories))
+			total += calories
+		}
+		totals = append(totals, total)
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(totals)))
+	return lines, totals[0], totals[0] + totals[1] + totals[2]
+}
+
+func writeGenerated(prefix string, lines []string, part1 int, part2 int) error {
+	if err := ioutil.WriteFile(prefix, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(prefix+".answers", []byte(fmt.Sprintf("%d\n%d\n", part1, part2)), 0644)
+}
+
 func main() {
 	var fileName string
+	var top int
+	var rank bool
+	var generate string
+	var seed int64
+	var size int
+	var reference bool
 	var part2 bool
+	var verbose bool
+	var veryVerbose bool
+	var traceFile string
 	var result int
 
 	flag.StringVar(&fileName, "f", "input", "Input file")
+	flag.IntVar(&top, "top", 0, "Total the heaviest N loads, 1 or 3 with -2 by default")
+	flag.BoolVar(&rank, "rank", false, "List the elves heaviest first, all of them unless -top is given")
+	flag.StringVar(&generate, "generate", "", "Write a random input to this file and its answers to the file plus .answers")
+	flag.Int64Var(&seed, "seed", 1, "Seed for -generate")
+	flag.IntVar(&size, "size", 250, "Number of elves for -generate")
+	flag.BoolVar(&reference, "reference", false, "Sort every load instead of streaming, for cross checking")
+	flag.BoolVar(&verbose, "v", false, "Log progress to STDERR")
+	flag.BoolVar(&veryVerbose, "vv", false, "Log progress and details to STDERR")
+	flag.StringVar(&traceFile, "trace", "", "Write each elf and the lightest load kept to this file as JSON lines")
 	flag.BoolVar(&part2, "2", false, "Compute part 2 of the exercise")
 	flag.Parse()
 
-	inputData, err := readFile(fileName)
+	flush, err := setupLogging(verbose, veryVerbose, traceFile)
 	if err != nil {
 		die(err)
 	}
+	closeTrace = flush
+	defer closeTrace()
 
-	loads, err := calculateLoads(inputData)
-	if nil != err {
+	if generate != "" {
+		lines, part1Answer, part2Answer := generateElves(rand.New(rand.NewSource(seed)), size)
+		if err := writeGenerated(generate, lines, part1Answer, part2Answer); err != nil {
+			die(err)
+		}
+		return
+	}
+
+	file, err := os.Open(fileName)
+	if err != nil {
 		die(err)
 	}
+	defer file.Close()
+
+	if rank {
+		var ranked []elf
+		if top == 0 {
+			var elves []elf
+			err = readElves(file, func(e elf) { elves = append(elves, e) })
+			if nil == err {
+				ranked, err = findTopElves(elves, len(elves))
+			}
+		} else {
+			ranked, err = streamTopElves(file, top)
+		}
+		if nil != err {
+			die(err)
+		}
+		for _, line := range rankingReport(ranked) {
+			fmt.Println(line)
+		}
+		return
+	}
 
-	if !part2 {
-		result = findHeaviestLoad(loads)
-	} else {
-		result, err = findTopThreeTotal(loads)
+	if top == 0 {
+		top = 1
+		if part2 {
+			top = 3
+		}
+	}
+	logger.Debug("Totalling the heaviest loads", "file", fileName, "top", top, "reference", reference)
+	if reference {
+		var loads []int
+		err = readElves(file, func(e elf) { loads = append(loads, e.calories) })
+		if nil == err {
+			result, err = findTopTotalReference(loads, top)
+		}
 		if nil != err {
 			die(err)
 		}
+		fmt.Println(result)
+		return
+	}
+	ranked, err := streamTopElves(file, top)
+	if nil != err {
+		die(err)
 	}
-	fmt.Println(result)
+	fmt.Println(totalCalories(ranked))
 }
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"flag"
+	"io"
 	"io/ioutil"
-	"os"
+	"log/slog"
+	"math/rand"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -22,164 +28,353 @@ var test_data = []string{
 	"30",
 }
 
-func createTestFile(contents []byte, t *testing.T) string {
-	tempFile, err := ioutil.TempFile("/tmp", "unit-test.*")
-	if err != nil {
-		t.Log("Failed to create temp file", err)
+func readAllElves(r io.Reader) ([]elf, error) {
+	var elves []elf
+	err := readElves(r, func(e elf) { elves = append(elves, e) })
+	return elves, err
+}
+
+/* The top n total the way main gets it, from a stream of elves */
+func streamTopTotal(loads []int, n int) (int, error) {
+	var lines []string
+	for _, load := range loads {
+		lines = append(lines, strconv.Itoa(load), "")
+	}
+	top, err := streamTopElves(strings.NewReader(strings.Join(lines, "\n")), n)
+	return totalCalories(top), err
+}
+
+func TestReadElvesLoads(t *testing.T) {
+	var result []int
+	err := readElves(strings.NewReader(strings.Join(test_data, "\n")), func(e elf) { result = append(result, e.calories) })
+	if nil != err {
+		t.Log("Unexpected error:", err)
+		t.Fail()
+	}
+	/* test_data doesn't end with a blank line, so this also checks the last elf is counted */
+	if !reflect.DeepEqual(result, []int{6, 600, 60}) {
+		t.Log("Expected:", []int{6, 600, 60}, "got:", result)
 		t.Fail()
 	}
 
-	if len(contents) != 0 {
-		if _, err := tempFile.Write(contents); err != nil {
-			t.Log("Failed to write to file:", err)
+	for _, bad := range []string{"A\nB", "\n\n\n"} {
+		if err := readElves(strings.NewReader(bad), func(e elf) {}); nil == err {
+			t.Log("Expected error, did not get one for", bad)
 			t.Fail()
 		}
 	}
+}
 
-	if err := tempFile.Close(); err != nil {
-		t.Log("Failed to write to file:", err)
+func TestStreamTopTotal(t *testing.T) {
+	result, err := streamTopTotal([]int{0, 2, 6, 3, 4}, 3)
+	if nil != err || result != 13 {
+		t.Log("Expected 13, got:", result, err)
 		t.Fail()
 	}
 
-	return tempFile.Name()
+	data := []int{5, 1, 9, 7, 3, 9}
+	for n, expected := range map[int]int{1: 9, 2: 18, 4: 30, 6: 34} {
+		result, err := streamTopTotal(data, n)
+		if nil != err || result != expected {
+			t.Log("Top", n, "expected", expected, "got", result, err)
+			t.Fail()
+		}
+	}
+	for _, n := range []int{0, 7} {
+		if _, err := streamTopTotal(data, n); nil == err {
+			t.Log("Expected error for top", n)
+			t.Fail()
+		}
+	}
 }
 
-func TestCreateTestFile(t *testing.T) {
-	contents := []byte("Line1\nLine2")
-	fileName := createTestFile(contents, t)
-	defer os.Remove(fileName)
-
-	readContents, err := readFile(fileName)
-	if err != nil {
-		t.Log("Failed to read file:", err)
+func TestTopNGrows(t *testing.T) {
+	top := newTopN(1 << 40)
+	top.offer(elf{1, 1, 10})
+	top.offer(elf{2, 1, 20})
+	if ranked := top.ranked(); !reflect.DeepEqual(ranked, []elf{{2, 1, 20}, {1, 1, 10}}) {
+		t.Log("Error, expected both elves, got", ranked)
 		t.Fail()
 	}
+}
 
-	if !reflect.DeepEqual(strings.Split(string(contents), "\n"), readContents) {
-		t.Log("Error, contents of test file differs", readContents)
+func TestFindTopElves(t *testing.T) {
+	elves := []elf{{1, 1, 10}, {2, 2, 30}, {3, 1, 20}, {4, 3, 30}, {5, 1, 5}}
+	ranked, err := findTopElves(elves, 3)
+	if nil != err {
+		t.Log("Unexpected error:", err)
 		t.Fail()
 	}
-}
-
-func TestReadFile(t *testing.T) {
-	contents := []byte("Line1\nLine2")
-	fileName := createTestFile(contents, t)
-	defer os.Remove(fileName)
-
-	readContents, err := readFile(fileName)
-	if err != nil {
-		t.Log("Failed to read file:", err)
+	// Ties go to the earlier elf
+	expected := []elf{{2, 2, 30}, {4, 3, 30}, {3, 1, 20}}
+	if !reflect.DeepEqual(ranked, expected) {
+		t.Log("Expected:", expected, "got:", ranked)
 		t.Fail()
 	}
 
-	if !reflect.DeepEqual(strings.Split(string(contents), "\n"), readContents) {
-		t.Log("Error, contents of test file differs", readContents)
+	lines := rankingReport(ranked[:2])
+	if !reflect.DeepEqual(lines, []string{"rank elf items calories", "1 2 2 30", "2 4 3 30"}) {
+		t.Log("Error, unexpected ranking report", lines)
 		t.Fail()
 	}
 }
 
-func TestReadFileSingleLine(t *testing.T) {
-	contents := []byte("Line1")
-	fileName := createTestFile(contents, t)
-	defer os.Remove(fileName)
-
-	readContents, err := readFile(fileName)
-	if err != nil {
-		t.Log("Failed to read file:", err)
+func TestReadElves(t *testing.T) {
+	var elves []elf
+	err := readElves(strings.NewReader("\n1\n2\n\n\n\n5\n\n7"), func(e elf) { elves = append(elves, e) })
+	if nil != err {
+		t.Log("Unexpected error:", err)
 		t.Fail()
 	}
-
-	if !reflect.DeepEqual(strings.Split(string(contents), "\n"), readContents) {
-		t.Log("Error, contents of test file differs", readContents)
+	expected := []elf{{1, 2, 3}, {2, 1, 5}, {3, 1, 7}}
+	if !reflect.DeepEqual(elves, expected) {
+		t.Log("Expected:", expected, "got:", elves)
 		t.Fail()
 	}
-}
 
-func TestReadFileNonexistent(t *testing.T) {
-	_, err := readFile("unit-test.does-not-exist")
-	if err == nil {
-		t.Log("Failed to error on non-existent file")
-		t.Fail()
+	for _, bad := range []string{"", "\n\n", "1\nx\n"} {
+		if err := readElves(strings.NewReader(bad), func(e elf) {}); nil == err {
+			t.Log("Expected error reading", bad)
+			t.Fail()
+		}
 	}
 }
 
-func TestReadFileEmpty(t *testing.T) {
-	tempFile, err := ioutil.TempFile("/tmp", "unit-test.*")
-	if err != nil {
-		t.Log("Failed to create temp file", err)
+func TestStreamTopElves(t *testing.T) {
+	ranked, err := streamTopElves(strings.NewReader(strings.Join(test_data, "\n")), 2)
+	if nil != err {
+		t.Log("Unexpected error:", err)
 		t.Fail()
 	}
-	defer os.Remove(tempFile.Name())
-
-	if err := tempFile.Close(); err != nil {
-		t.Log("Failed to write to file:", err)
+	expected := []elf{{2, 3, 600}, {3, 3, 60}}
+	if !reflect.DeepEqual(ranked, expected) {
+		t.Log("Expected:", expected, "got:", ranked)
 		t.Fail()
 	}
 
-	readContents, err := readFile(tempFile.Name())
-	if err == nil {
-		t.Log("Should error on empty file, got:", readContents)
+	if _, err := streamTopElves(strings.NewReader(strings.Join(test_data, "\n")), 4); nil == err {
+		t.Log("Expected error for too few elves")
 		t.Fail()
 	}
 }
 
-func TestReadFileSingleNewline(t *testing.T) {
-	contents := []byte("\n")
-	fileName := createTestFile(contents, t)
-	defer os.Remove(fileName)
+/*
+ * Writes elves with one to fifteen items each until at least size bytes have
+ * been produced, without holding more than one elf's lines
+ */
+type elfGenerator struct {
+	random  *rand.Rand
+	size    int64
+	written int64
+	buffer  []byte
+	pending []byte
+}
 
-	readContents, err := readFile(fileName)
-	if err == nil {
-		t.Log("Got contents where there should be none:", readContents)
-		t.Fail()
+func newElfGenerator(size int64, seed int64) *elfGenerator {
+	return &elfGenerator{random: rand.New(rand.NewSource(seed)), size: size}
+}
+
+func (g *elfGenerator) Read(p []byte) (int, error) {
+	var n int
+	for n < len(p) {
+		if len(g.pending) == 0 {
+			if g.written >= g.size {
+				break
+			}
+			g.buffer = g.buffer[:0]
+			if g.written > 0 {
+				g.buffer = append(g.buffer, '\n')
+			}
+			for items := 1 + g.random.Intn(15); items > 0; items-- {
+				g.buffer = strconv.AppendInt(g.buffer, int64(1000+g.random.Intn(59000)), 10)
+				g.buffer = append(g.buffer, '\n')
+			}
+			g.pending = g.buffer
+			g.written += int64(len(g.pending))
+		}
+		copied := copy(p[n:], g.pending)
+		g.pending = g.pending[copied:]
+		n += copied
+	}
+	if n == 0 {
+		return 0, io.EOF
 	}
+	return n, nil
 }
 
-func TestCalculateLoads(t *testing.T) {
-	expected := []int{6, 600, 60}
-	result, err := calculateLoads(test_data)
+var elfBytes = flag.Int64("elf.bytes", 2<<30, "Size of the generated input for the streaming benchmark")
+
+func TestElfGenerator(t *testing.T) {
+	data, err := ioutil.ReadAll(newElfGenerator(10000, 1))
+	if nil != err {
+		t.Log("Unexpected error:", err)
+		t.Fail()
+	}
+	elves, err := readAllElves(bytes.NewReader(data))
 	if nil != err {
 		t.Log("Unexpected error:", err)
 		t.Fail()
 	}
+	expected, _ := findTopElves(elves, len(elves))
+	ranked, err := streamTopElves(bytes.NewReader(data), len(elves))
+	if nil != err || !reflect.DeepEqual(ranked, expected) {
+		t.Log("Error, streamed and collected elves differ", err)
+		t.Fail()
+	}
+	if len(data) < 10000 || data[len(data)-1] != '\n' || data[len(data)-2] == '\n' {
+		t.Log("Error, expected at least 10000 bytes ending in a single newline, got", len(data))
+		t.Fail()
+	}
+}
+
+func BenchmarkStreamTopElves(b *testing.B) {
+	b.SetBytes(*elfBytes)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := streamTopElves(newElfGenerator(*elfBytes, 1), 3); nil != err {
+			b.Fatal(err)
+		}
+	}
+}
 
-	for i := range result {
-		if result[i] != expected[i] {
-			t.Log("Expected:", expected, "got:", result)
+func TestParseLoad(t *testing.T) {
+	for _, item := range []string{"0", "123", "+7", "-42", "99999999999999999999", "", "-", "1x", " 1"} {
+		expected, expectedErr := strconv.Atoi(item)
+		load, err := parseLoad([]byte(item))
+		if load != expected || (err == nil) != (expectedErr == nil) {
+			t.Log("Parsing", item, "expected", expected, expectedErr, "got", load, err)
 			t.Fail()
 		}
 	}
 }
 
-func TestCalculateLoadsErrors(t *testing.T) {
-	data := []string{"A", "B"}
-	var result []int
-	var err error
-	result, err = calculateLoads(data)
-	if nil == err {
-		t.Log("Expected error, did not get one. Result:", result)
-		t.Fail()
+func TestGenerateElves(t *testing.T) {
+	for _, size := range []int{1, 3, 4, 250} {
+		lines, part1, part2 := generateElves(rand.New(rand.NewSource(int64(size))), size)
+		input := strings.Join(lines, "\n") + "\n"
+		for n, expected := range map[int]int{1: part1, 3: part2} {
+			top, err := streamTopElves(strings.NewReader(input), n)
+			total := totalCalories(top)
+			if err != nil || total != expected {
+				t.Log("Expected the top", n, "of", size, "elves to carry", expected, "got", total, err)
+				t.Fail()
+			}
+		}
 	}
+}
 
-	data = []string{"", "", ""}
-	result, err = calculateLoads(data)
-	if nil == err {
-		t.Log("Expected error, did not get one. Result:", result)
-		t.Fail()
+func FuzzReadElves(f *testing.F) {
+	f.Add("1000\n2000\n3000\n\n4000\n\n5000\n6000\n\n7000\n8000\n9000\n\n10000\n")
+	f.Add("\n\n1\n\n\n-2\n+3")
+	f.Add("12x\n99999999999999999999\n")
+	f.Fuzz(func(t *testing.T, input string) {
+		elves, err := readAllElves(strings.NewReader(input))
+		if err != nil {
+			return
+		}
+
+		/* The scanner also drops a carriage return before each newline */
+		if !strings.Contains(input, "\r") {
+			items, lines := 0, 0
+			for _, e := range elves {
+				items += e.items
+			}
+			for _, line := range strings.Split(input, "\n") {
+				if line != "" {
+					lines++
+				}
+			}
+			if items != lines {
+				t.Log("Error, read", items, "items from", lines, "lines")
+				t.Fail()
+			}
+		}
+
+		ranked, err := findTopElves(elves, len(elves))
+		if err != nil || len(ranked) != len(elves) {
+			t.Log("Error, ranked", ranked, "of", elves, err)
+			t.Fail()
+		}
+		top, err := streamTopElves(strings.NewReader(input), 1)
+		if err != nil || len(top) != 1 || top[0] != ranked[0] {
+			t.Log("Error, streamed top elf", top, "but ranked", ranked[0], err)
+			t.Fail()
+		}
+	})
+}
+
+func FuzzParseLoad(f *testing.F) {
+	for _, item := range []string{"0", "123", "+7", "-42", "99999999999999999999", "", "-", "1x"} {
+		f.Add(item)
 	}
+	f.Fuzz(func(t *testing.T, item string) {
+		expected, expectedErr := strconv.Atoi(item)
+		load, err := parseLoad([]byte(item))
+		if load != expected || (err == nil) != (expectedErr == nil) {
+			t.Log("Parsing", item, "expected", expected, expectedErr, "got", load, err)
+			t.Fail()
+		}
+	})
 }
 
-func TestFindTopThreeTotal(t *testing.T) {
-	data := []int{0, 2, 6, 3, 4}
-	var result int
-	var err error
-	result, err = findTopThreeTotal(data)
-	if nil != err {
-		t.Log("Unexpected error:", err)
+func TestFindTopTotalReference(t *testing.T) {
+	food := []int{6000, 4000, 11000, 24000, 10000}
+	for n := 1; n <= len(food); n++ {
+		expected, err := streamTopTotal(food, n)
+		result, referenceErr := findTopTotalReference(food, n)
+		if err != nil || referenceErr != nil || result != expected {
+			t.Log("Top", n, "expected", expected, err, "got", result, referenceErr)
+			t.Fail()
+		}
+	}
+	for _, n := range []int{0, 6} {
+		if _, err := findTopTotalReference(food, n); err == nil {
+			t.Log("Expected error for the top", n)
+			t.Fail()
+		}
+	}
+}
+
+func TestSetupLogging(t *testing.T) {
+	defer func() { tracer = nil }()
+	levels := []struct {
+		verbose     bool
+		veryVerbose bool
+		lowest      slog.Level
+	}{
+		{false, false, slog.LevelWarn},
+		{true, false, slog.LevelInfo},
+		{false, true, slog.LevelDebug},
+		{true, true, slog.LevelDebug},
+	}
+	for _, level := range levels {
+		if _, err := setupLogging(level.verbose, level.veryVerbose, ""); err != nil {
+			t.Log("Failed to set up logging:", err)
+			t.FailNow()
+		}
+		if !logger.Enabled(context.Background(), level.lowest) || logger.Enabled(context.Background(), level.lowest-1) {
+			t.Log("Error, expected", level.lowest, "to be the lowest level logged for", level.verbose, level.veryVerbose)
+			t.Fail()
+		}
+		if tracer != nil {
+			t.Log("Error, tracing without -trace")
+			t.Fail()
+		}
+	}
+
+	traceFile := t.TempDir() + "/trace.jsonl"
+	flush, err := setupLogging(false, false, traceFile)
+	if err != nil || tracer == nil {
+		t.Log("Failed to start tracing:", err)
+		t.FailNow()
+	}
+	if _, err := streamTopElves(strings.NewReader("1\n\n2\n"), 1); err != nil {
+		t.Log("Failed to total elves:", err)
 		t.Fail()
 	}
-	if result != 13 {
-		t.Log("Expected 13, got:", result)
+	flush()
+	if trace, _ := ioutil.ReadFile(traceFile); strings.Count(string(trace), `"msg":"elf"`) != 2 {
+		t.Log("Error, expected a trace line per elf, got", string(trace))
 		t.Fail()
 	}
 }
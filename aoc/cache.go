@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+ * Answers are cached per part against hashes of the input and of the built
+ * solver, so editing either one simply misses. Storing an answer clears out
+ * the part's older entries, which could only be hit again by reverting.
+ */
+type answerCache struct {
+	dir string
+}
+
+type cacheKey struct {
+	year  string
+	day   string
+	part  int
+	input string // SHA-256 of the input file
+	build string // SHA-256 of the solver binary
+}
+
+type cacheEntry struct {
+	Year     string        `json:"year"`
+	Day      string        `json:"day"`
+	Part     int           `json:"part"`
+	Input    string        `json:"input_sha256"`
+	Build    string        `json:"build_id"`
+	Answer   string        `json:"answer"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+func openCache(dir string) (*answerCache, error) {
+	if dir == "" {
+		userCache, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(userCache, "aoc", "answers")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &answerCache{dir: dir}, nil
+}
+
+func hashFile(name string) (string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (c *answerCache) partPattern(k cacheKey) string {
+	return filepath.Join(c.dir, k.year, k.day, fmt.Sprintf("part%d-*.json", k.part))
+}
+
+/* Names are shortened hashes; the entry itself holds them in full */
+func (c *answerCache) path(k cacheKey) string {
+	return filepath.Join(c.dir, k.year, k.day, fmt.Sprintf("part%d-%.16s-%.16s.json", k.part, k.input, k.build))
+}
+
+func (c *answerCache) load(k cacheKey) (cacheEntry, bool) {
+	var entry cacheEntry
+	data, err := os.ReadFile(c.path(k))
+	if err != nil {
+		return entry, false
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return entry, false
+	}
+	if entry.Year != k.year || entry.Day != k.day || entry.Part != k.part || entry.Input != k.input || entry.Build != k.build {
+		return entry, false
+	}
+	return entry, true
+}
+
+func (c *answerCache) store(k cacheKey, answer string, took time.Duration) error {
+	stale, err := filepath.Glob(c.partPattern(k))
+	if err != nil {
+		return err
+	}
+	for _, name := range stale {
+		if err := os.Remove(name); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(cacheEntry{k.year, k.day, k.part, k.input, k.build, answer, took})
+	if err != nil {
+		return err
+	}
+	name := c.path(k)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return writeAtomically(name, data, 0644)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAnswerCache(t *testing.T) {
+	cache, err := openCache(t.TempDir())
+	if err != nil {
+		t.Log("Unexpected error:", err)
+		t.FailNow()
+	}
+	key := cacheKey{"2020", "4", 2, "aaaaaaaaaaaaaaaaaaaa", "bbbbbbbbbbbbbbbbbbbb"}
+	if _, hit := cache.load(key); hit {
+		t.Log("Error, hit in an empty cache")
+		t.Fail()
+	}
+
+	if err := cache.store(key, "150", time.Millisecond); err != nil {
+		t.Log("Unexpected error:", err)
+		t.FailNow()
+	}
+	entry, hit := cache.load(key)
+	if !hit || entry.Answer != "150" || entry.Duration != time.Millisecond {
+		t.Log("Error, expected a hit for 150, got", entry, hit)
+		t.Fail()
+	}
+
+	for _, miss := range []cacheKey{
+		{"2020", "4", 1, key.input, key.build},
+		{"2020", "4", 2, "aaaaaaaaaaaaaaaaaaaZ", key.build},
+		{"2020", "4", 2, key.input, "bbbbbbbbbbbbbbbbbbbZ"},
+	} {
+		if entry, hit := cache.load(miss); hit {
+			t.Log("Error, unexpected hit for", miss, entry)
+			t.Fail()
+		}
+	}
+
+	/* A new build replaces the part's entry, leaving the other part's alone */
+	other := cacheKey{"2020", "4", 1, key.input, key.build}
+	rebuilt := cacheKey{"2020", "4", 2, key.input, "cccccccccccccccccccc"}
+	cache.store(other, "216", time.Millisecond)
+	if err := cache.store(rebuilt, "151", time.Millisecond); err != nil {
+		t.Log("Unexpected error:", err)
+		t.Fail()
+	}
+	if _, hit := cache.load(key); hit {
+		t.Log("Error, the old build's entry is still there")
+		t.Fail()
+	}
+	if _, hit := cache.load(other); !hit {
+		t.Log("Error, part 1's entry was removed")
+		t.Fail()
+	}
+	entries, _ := filepath.Glob(filepath.Join(cache.dir, "2020", "4", "*"))
+	if len(entries) != 2 {
+		t.Log("Error, expected two entries, got", entries)
+		t.Fail()
+	}
+}
+
+func TestAnswerCacheCorrupt(t *testing.T) {
+	cache, _ := openCache(t.TempDir())
+	key := cacheKey{"2020", "4", 1, "aaaaaaaaaaaaaaaaaaaa", "bbbbbbbbbbbbbbbbbbbb"}
+	os.MkdirAll(filepath.Join(cache.dir, "2020", "4"), 0755)
+	os.WriteFile(cache.path(key), []byte("{"), 0644)
+	if _, hit := cache.load(key); hit {
+		t.Log("Error, hit on a corrupt entry")
+		t.Fail()
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+ * A day registers a reference solver by taking a -reference flag: a slow,
+ * obviously correct version of the answer to check an optimised one against.
+ * Cross checking runs both on the real input and on inputs from the day's
+ * -generate, stopping at the first disagreement and cutting that input down
+ * to as few lines as still disagree.
+ */
+func crosscheckCommand(args []string) error {
+	flags := flag.NewFlagSet("crosscheck", flag.ContinueOnError)
+	root := flags.String("root", "", "Repository root, defaults to the nearest directory above with year directories")
+	seeds := flags.Int("seeds", 100, "Number of generated inputs to check")
+	size := flags.String("size", "", "Size of each generated input, defaults to the day's own")
+	save := flags.String("o", "", "Also write a minimised counterexample to this file")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: aoc crosscheck [flags] YEAR DAY")
+		flags.PrintDefaults()
+	}
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+
+	if *root, err = resolveRoot(*root); err != nil {
+		return err
+	}
+	d, err := findDay(*root, positional[0], positional[1])
+	if err != nil {
+		return err
+	}
+	work, err := os.MkdirTemp("", "aoc-crosscheck")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+	if err := d.build(work); err != nil {
+		return err
+	}
+
+	failure, err := crosscheck(d, work, *seeds, *size, os.Stderr)
+	if err != nil {
+		return err
+	}
+	if failure == nil {
+		return nil
+	}
+	failure.report(os.Stdout)
+	if *save != "" {
+		if err := os.WriteFile(*save, []byte(joinLines(failure.minimal)), 0644); err != nil {
+			return err
+		}
+	}
+	return errFailed
+}
+
+/* The first input the two solvers disagree on */
+type disagreement struct {
+	day              *day
+	part             int
+	input            string // where the input came from
+	fast             outcome
+	reference        outcome
+	minimal          []string
+	minimalFast      outcome
+	minimalReference outcome
+}
+
+func (f *disagreement) report(w io.Writer) {
+	fmt.Fprintf(w, "%s part %d: solvers disagree on %s\n", f.day, f.part, f.input)
+	fmt.Fprintf(w, "  fast:      %s\n  reference: %s\n", f.fast, f.reference)
+	fmt.Fprintf(w, "Minimised to %d lines, where fast gives %s and reference %s:\n", len(f.minimal), f.minimalFast, f.minimalReference)
+	for _, line := range f.minimal {
+		fmt.Fprintf(w, "  %s\n", line)
+	}
+}
+
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+/* Notes go to progress; a nil disagreement means the solvers agreed on everything */
+func crosscheck(d *day, work string, seeds int, size string, progress io.Writer) (*disagreement, error) {
+	hasReference, err := d.supports("reference")
+	if err != nil {
+		return nil, err
+	}
+	if !hasReference {
+		return nil, fmt.Errorf("%s has no reference solver, it needs a -reference flag", d)
+	}
+	canGenerate, err := d.supports("generate")
+	if err != nil {
+		return nil, err
+	}
+
+	type input struct {
+		name string
+		file string
+		seed int64 // to generate the file with, or 0 for the real input
+	}
+	var inputs []input
+	puzzle, err := d.inputFile(work)
+	if err == nil {
+		inputs = append(inputs, input{"the real input", puzzle, 0})
+	} else if !errors.Is(err, errNoInput) {
+		return nil, err
+	}
+	if canGenerate {
+		for seed := int64(1); seed <= int64(seeds); seed++ {
+			file := filepath.Join(work, fmt.Sprintf("generated-%d", seed))
+			inputs = append(inputs, input{fmt.Sprintf("the generated input with seed %d", seed), file, seed})
+		}
+	} else {
+		fmt.Fprintf(progress, "%s has no -generate, only checking the real input\n", d)
+	}
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("%s has no input to check", d)
+	}
+
+	for _, in := range inputs {
+		if in.seed != 0 {
+			if err := d.generate(in.file, in.seed, size); err != nil {
+				return nil, err
+			}
+		}
+		for part := 1; part <= 2; part++ {
+			fast, reference, err := compare(d, in.file, part)
+			if err != nil {
+				return nil, err
+			}
+			if fast == reference {
+				continue
+			}
+			failure := &disagreement{day: d, part: part, input: in.name, fast: fast, reference: reference}
+			if err := failure.minimise(work, in.file); err != nil {
+				return nil, err
+			}
+			return failure, nil
+		}
+	}
+	fmt.Fprintf(progress, "%s: solvers agree on %d inputs\n", d, len(inputs))
+	return nil, nil
+}
+
+func fileExists(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && !info.IsDir()
+}
+
+func compare(d *day, input string, part int) (outcome, outcome, error) {
+	fast, err := d.solve(input, part)
+	if err != nil {
+		return fast, fast, err
+	}
+	reference, err := d.solve(input, part, "-reference")
+	return fast, reference, err
+}
+
+/* Keeps cutting lines while the solvers still disagree, and fail or succeed, the same way */
+func (f *disagreement) minimise(work string, file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	candidate := filepath.Join(work, "candidate")
+	f.minimalFast, f.minimalReference = f.fast, f.reference
+	f.minimal, err = minimise(lines, func(lines []string) (bool, error) {
+		if err := os.WriteFile(candidate, []byte(joinLines(lines)), 0644); err != nil {
+			return false, err
+		}
+		fast, reference, err := compare(f.day, candidate, f.part)
+		if err != nil {
+			return false, err
+		}
+		if fast == reference || fast.ok != f.fast.ok || reference.ok != f.reference.ok {
+			return false, nil
+		}
+		f.minimalFast, f.minimalReference = fast, reference
+		return true, nil
+	})
+	return err
+}
+
+/*
+ * Removes runs of lines, halving the run length down to single lines, for as
+ * long as what's left still fails. Single lines are retried until none can
+ * go, so no one line can be removed from the result.
+ */
+func minimise(lines []string, fails func([]string) (bool, error)) ([]string, error) {
+	chunk := len(lines) / 2
+	if chunk < 1 {
+		chunk = 1
+	}
+	for {
+		removed := false
+		for start := 0; start < len(lines); {
+			end := start + chunk
+			if end > len(lines) {
+				end = len(lines)
+			}
+			candidate := append(append([]string{}, lines[:start]...), lines[end:]...)
+			failed, err := fails(candidate)
+			if err != nil {
+				return nil, err
+			}
+			if failed {
+				lines = candidate
+				removed = true
+			} else {
+				start = end
+			}
+		}
+		if chunk > 1 {
+			chunk /= 2
+		} else if !removed {
+			return lines, nil
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMinimise(t *testing.T) {
+	var tries int
+	/* Fails as long as both 3 and 7 are left */
+	fails := func(lines []string) (bool, error) {
+		tries++
+		joined := " " + strings.Join(lines, " ") + " "
+		return strings.Contains(joined, " 3 ") && strings.Contains(joined, " 7 "), nil
+	}
+	lines := strings.Fields("0 1 2 3 4 5 6 7 8 9 10 11 12 13 14 15")
+	minimal, err := minimise(lines, fails)
+	if err != nil || !reflect.DeepEqual(minimal, []string{"3", "7"}) {
+		t.Log("Error, expected [3 7], got", minimal, err)
+		t.Fail()
+	}
+	if tries > 40 {
+		t.Log("Error, took", tries, "tries to minimise 16 lines")
+		t.Fail()
+	}
+
+	minimal, err = minimise([]string{"3"}, func(lines []string) (bool, error) { return len(lines) == 1, nil })
+	if err != nil || !reflect.DeepEqual(minimal, []string{"3"}) {
+		t.Log("Error, expected [3], got", minimal, err)
+		t.Fail()
+	}
+}
+
+func TestCrosscheck(t *testing.T) {
+	root := createTestRoot(t, map[string]string{"2099/1": testDaySource})
+	os.WriteFile(filepath.Join(root, "2099", "1", "input"), []byte("1\n2\n3\n"), 0644)
+	d, _ := findDay(root, "2099", "1")
+	work := t.TempDir()
+	if err := d.build(work); err != nil {
+		t.Log("Unexpected error:", err)
+		t.FailNow()
+	}
+
+	/* Seed 1 generates 7 10 13 16 ..., the first input with a 13 in it */
+	failure, err := crosscheck(d, work, 5, "", ioutil.Discard)
+	if err != nil || failure == nil {
+		t.Log("Expected a disagreement, got", failure, err)
+		t.FailNow()
+	}
+	if failure.part != 1 || failure.input != "the generated input with seed 1" ||
+		failure.fast == failure.reference || !reflect.DeepEqual(failure.minimal, []string{"13"}) ||
+		failure.minimalFast != (outcome{"0", true}) || failure.minimalReference != (outcome{"13", true}) {
+		t.Log("Error, unexpected disagreement", failure)
+		t.Fail()
+	}
+
+	/* With no generated inputs only the real input is checked, and it has no 13 */
+	failure, err = crosscheck(d, work, 0, "", ioutil.Discard)
+	if err != nil || failure != nil {
+		t.Log("Expected agreement on the real input, got", failure, err)
+		t.Fail()
+	}
+}
+
+func TestCrosscheckNoReference(t *testing.T) {
+	source := strings.Replace(testDaySource, `flag.Bool("reference"`, `flag.Bool("slow"`, 1)
+	root := createTestRoot(t, map[string]string{"2099/2": source})
+	d, _ := findDay(root, "2099", "2")
+	work := t.TempDir()
+	if err := d.build(work); err != nil {
+		t.Log("Unexpected error:", err)
+		t.FailNow()
+	}
+	if _, err := crosscheck(d, work, 1, "", ioutil.Discard); err == nil || !strings.Contains(err.Error(), "no reference") {
+		t.Log("Expected error for a day with no reference solver, got", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var yearPattern = regexp.MustCompile(`^[0-9]{4}$`)
+
+/* The repository root is the nearest directory at or above start holding a year directory */
+func findRoot(start string) (string, error) {
+	dir, err := filepath.Abs(start)
+	if err != nil {
+		return "", err
+	}
+	for {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return "", err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() && yearPattern.MatchString(entry.Name()) {
+				return dir, nil
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("No year directories found at or above %s", start)
+		}
+		dir = parent
+	}
+}
+
+/*
+ * The -root flag, or the root found from the current directory without one.
+ * Solvers run in their own directories, so it's always made absolute.
+ */
+func resolveRoot(root string) (string, error) {
+	if root == "" {
+		return findRoot(".")
+	}
+	return filepath.Abs(root)
+}
+
+type day struct {
+	root    string
+	year    string
+	number  string
+	dir     string
+	binary  string    // set by build
+	buildID string    // SHA-256 of the binary
+	stderr  io.Writer // where the solver's errors go, discarded if nil
+}
+
+func (d *day) String() string {
+	return d.year + "/" + d.number
+}
+
+/* Every day with a solver, or just those in year if it isn't empty, in order */
+func findDays(root string, year string) ([]*day, error) {
+	all, err := listDays(root, year)
+	if err != nil {
+		return nil, err
+	}
+	var days []*day
+	for _, d := range all {
+		if d, err := findDay(root, d.year, d.number); err == nil {
+			days = append(days, d)
+		}
+	}
+	if len(days) == 0 {
+		return nil, fmt.Errorf("No solvers found in %s", filepath.Join(root, year))
+	}
+	return days, nil
+}
+
+/* Every day directory, solver or not, in order */
+func listDays(root string, year string) ([]*day, error) {
+	years := []string{year}
+	if year == "" {
+		var err error
+		if years, err = numberedDirs(root); err != nil {
+			return nil, err
+		}
+	}
+	var days []*day
+	for _, y := range years {
+		numbers, err := numberedDirs(filepath.Join(root, y))
+		if err != nil {
+			return nil, err
+		}
+		for _, number := range numbers {
+			days = append(days, &day{root: root, year: y, number: number, dir: filepath.Join(root, y, number)})
+		}
+	}
+	return days, nil
+}
+
+/* Subdirectories named by number, in numerical order so day 10 comes after day 9 */
+func numberedDirs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); entry.IsDir() && err == nil {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, _ := strconv.Atoi(names[i])
+		b, _ := strconv.Atoi(names[j])
+		return a < b
+	})
+	return names, nil
+}
+
+func findDay(root string, year string, number string) (*day, error) {
+	d := &day{root: root, year: year, number: number, dir: filepath.Join(root, year, number)}
+	sources, err := filepath.Glob(filepath.Join(d.dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("No solver for %s in %s", d, d.dir)
+	}
+	return d, nil
+}
+
+/* Days without a go.mod of their own or above them build in GOPATH mode */
+func (d *day) environment() []string {
+	for dir := d.dir; ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return os.Environ()
+		}
+		if dir == d.root || dir == filepath.Dir(dir) {
+			return append(os.Environ(), "GO111MODULE=off")
+		}
+	}
+}
+
+/* Builds the solver into work, so it can be run many times without recompiling */
+func (d *day) build(work string) error {
+	binary := filepath.Join(work, d.year+"-"+d.number)
+	cmd := exec.Command("go", "build", "-o", binary, ".")
+	cmd.Dir = d.dir
+	cmd.Env = d.environment()
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("Building %s: %s\n%s", d, err, output)
+	}
+	buildID, err := hashFile(binary)
+	if err != nil {
+		return err
+	}
+	d.binary, d.buildID = binary, buildID
+	return nil
+}
+
+/* What a solver printed, and whether it exited cleanly */
+type outcome struct {
+	answer string
+	ok     bool
+}
+
+func (o outcome) String() string {
+	if !o.ok {
+		return fmt.Sprintf("failed (%q)", o.answer)
+	}
+	return o.answer
+}
+
+/* Runs the solver from its own directory; a solver exiting with an error is an outcome, not an error */
+func (d *day) execute(args ...string) (outcome, error) {
+	if d.binary == "" {
+		return outcome{}, fmt.Errorf("%s hasn't been built", d)
+	}
+	var stdout bytes.Buffer
+	cmd := exec.Command(d.binary, args...)
+	cmd.Dir = d.dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = d.stderr
+	err := cmd.Run()
+	var exit *exec.ExitError
+	if err != nil && !errors.As(err, &exit) {
+		return outcome{}, fmt.Errorf("Running %s: %s", d, err)
+	}
+	return outcome{strings.TrimSpace(stdout.String()), err == nil}, nil
+}
+
+func (d *day) solve(input string, part int, extra ...string) (outcome, error) {
+	args := []string{"-f", input}
+	if part == 2 {
+		args = append(args, "-2")
+	}
+	return d.execute(append(args, extra...)...)
+}
+
+/* Whether the solver has a flag, going by its usage message */
+func (d *day) supports(name string) (bool, error) {
+	if d.binary == "" {
+		return false, fmt.Errorf("%s hasn't been built", d)
+	}
+	cmd := exec.Command(d.binary, "-h")
+	cmd.Dir = d.dir
+	output, _ := cmd.CombinedOutput()
+	defined := regexp.MustCompile(`(?m)^\s+-` + regexp.QuoteMeta(name) + `(\s|$)`)
+	return defined.Match(output), nil
+}
+
+/* Has the solver write a random input to prefix and its answers to prefix.answers */
+func (d *day) generate(prefix string, seed int64, size string) error {
+	args := []string{"-generate", prefix, "-seed", fmt.Sprint(seed)}
+	if size != "" {
+		args = append(args, "-size", size)
+	}
+	result, err := d.execute(args...)
+	if err != nil {
+		return err
+	}
+	if !result.ok {
+		return fmt.Errorf("Generating an input for %s with seed %d failed: %s", d, seed, result.answer)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/* Lays out a repository with one day per source, keyed by "year/day" */
+func createTestRoot(t *testing.T, days map[string]string) string {
+	root := t.TempDir()
+	for name, source := range days {
+		dir := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Log("Failed to create day:", err)
+			t.FailNow()
+		}
+		if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(source), 0644); err != nil {
+			t.Log("Failed to write day:", err)
+			t.FailNow()
+		}
+	}
+	return root
+}
+
+/* Adds the numbers in its input, twice over for part 2; the fast way forgets 13s */
+const testDaySource = `package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	file := flag.String("f", "input", "Input file")
+	part2 := flag.Bool("2", false, "Compute part 2 of the exercise")
+	reference := flag.Bool("reference", false, "Count every number")
+	generate := flag.String("generate", "", "Write a random input")
+	seed := flag.Int64("seed", 1, "Seed for -generate")
+	flag.Parse()
+
+	if *generate != "" {
+		var lines []string
+		for i := int64(0); i < 10; i++ {
+			lines = append(lines, strconv.FormatInt((*seed*7+i*3)%20, 10))
+		}
+		os.WriteFile(*generate, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+		os.WriteFile(*generate+".answers", []byte("0\n0\n"), 0644)
+		return
+	}
+
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	sum := 0
+	for _, field := range strings.Fields(string(data)) {
+		number, err := strconv.Atoi(field)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if number == 13 && !*reference {
+			continue
+		}
+		if *part2 {
+			number *= 2
+		}
+		sum += number
+	}
+	fmt.Println(sum)
+}
+`
+
+func TestFindRoot(t *testing.T) {
+	root := createTestRoot(t, map[string]string{"2099/1": testDaySource})
+	for _, start := range []string{root, filepath.Join(root, "2099", "1")} {
+		found, err := findRoot(start)
+		if err != nil || found != root {
+			t.Log("Expected root", root, "from", start, "got", found, err)
+			t.Fail()
+		}
+	}
+	if found, err := findRoot(t.TempDir()); err == nil {
+		t.Log("Expected no root, got", found)
+		t.Fail()
+	}
+}
+
+func TestResolveRoot(t *testing.T) {
+	root := createTestRoot(t, map[string]string{"2099/1": testDaySource})
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(filepath.Join(root, "2099"))
+	for _, flag := range []string{"", ".."} {
+		resolved, err := resolveRoot(flag)
+		if err != nil || resolved != root {
+			t.Log("Expected root", root, "for -root", flag, "got", resolved, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestFindDay(t *testing.T) {
+	root := createTestRoot(t, map[string]string{"2099/1": testDaySource})
+	if _, err := findDay(root, "2099", "1"); err != nil {
+		t.Log("Unexpected error:", err)
+		t.Fail()
+	}
+	if _, err := findDay(root, "2099", "2"); err == nil {
+		t.Log("Expected error for a missing day")
+		t.Fail()
+	}
+}
+
+func TestEnvironment(t *testing.T) {
+	root := createTestRoot(t, map[string]string{"2099/1": testDaySource, "2098/1": testDaySource})
+	if err := os.WriteFile(filepath.Join(root, "2098", "go.mod"), []byte("module m\n"), 0644); err != nil {
+		t.Log("Failed to write go.mod:", err)
+		t.FailNow()
+	}
+	for name, gopath := range map[string]bool{"2099": true, "2098": false} {
+		d, err := findDay(root, name, "1")
+		if err != nil {
+			t.Log("Unexpected error:", err)
+			t.FailNow()
+		}
+		environment := strings.Join(d.environment(), "\n")
+		if strings.HasSuffix(environment, "GO111MODULE=off") != gopath {
+			t.Log("Error,", name, "should build in GOPATH mode:", gopath)
+			t.Fail()
+		}
+	}
+}
+
+func TestBuildAndSolve(t *testing.T) {
+	root := createTestRoot(t, map[string]string{"2099/1": testDaySource})
+	d, _ := findDay(root, "2099", "1")
+	if err := d.build(t.TempDir()); err != nil {
+		t.Log("Unexpected error:", err)
+		t.FailNow()
+	}
+
+	input := filepath.Join(t.TempDir(), "input")
+	os.WriteFile(input, []byte("1\n13\n2\n"), 0644)
+	for _, c := range []struct {
+		part     int
+		extra    []string
+		expected outcome
+	}{
+		{1, nil, outcome{"3", true}},
+		{2, nil, outcome{"6", true}},
+		{1, []string{"-reference"}, outcome{"16", true}},
+		{1, []string{"-f", "missing"}, outcome{"", false}},
+	} {
+		result, err := d.solve(input, c.part, c.extra...)
+		if err != nil || result != c.expected {
+			t.Log("Part", c.part, c.extra, "expected", c.expected, "got", result, err)
+			t.Fail()
+		}
+	}
+
+	for name, expected := range map[string]bool{"reference": true, "generate": true, "f": true, "size": false, "refer": false} {
+		if supported, err := d.supports(name); err != nil || supported != expected {
+			t.Log("Expected", name, "supported to be", expected, "got", supported, err)
+			t.Fail()
+		}
+	}
+}
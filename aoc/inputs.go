@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+ * Puzzle inputs aren't meant to be published, so a day can keep input.enc in
+ * the repository instead of input: the input sealed with AES-256-GCM under a
+ * key that stays on each machine. The day, as "YEAR/DAY", is authenticated
+ * along with it, so an encrypted input copied to the wrong day won't decrypt.
+ */
+
+const (
+	keyEnv       = "AOC_INPUT_KEY"      // the key in hex
+	keyFileEnv   = "AOC_INPUT_KEY_FILE" // or a file holding it
+	inputMagic   = "aoc-input-v1\n"
+	plainInput   = "input"
+	sealedInput  = "input.enc"
+	inputKeySize = 32
+)
+
+/* Returned when a day has neither a plain nor an encrypted input */
+var errNoInput = errors.New("No input")
+
+const inputsUsage = `Usage: aoc inputs SUBCOMMAND [flags] [YEAR [DAY]]
+
+Subcommands:
+  keygen   Create a new key in the key file
+  encrypt  Write each day's input to input.enc, encrypted
+  decrypt  Write each day's input.enc back to input
+
+The key is read from $AOC_INPUT_KEY in hex, or else from the file named by
+$AOC_INPUT_KEY_FILE, which defaults to aoc/input.key in the user config
+directory.
+`
+
+func inputsCommand(args []string) error {
+	subcommands := map[string]func(args []string) error{
+		"keygen":  keygenCommand,
+		"encrypt": encryptCommand,
+		"decrypt": decryptCommand,
+	}
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, inputsUsage)
+		return flag.ErrHelp
+	}
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		fmt.Print(inputsUsage)
+		return flag.ErrHelp
+	}
+	subcommand, ok := subcommands[args[0]]
+	if !ok {
+		return fmt.Errorf("Unknown inputs subcommand %q", args[0])
+	}
+	return subcommand(args[1:])
+}
+
+func keyFile() (string, error) {
+	if name := os.Getenv(keyFileEnv); name != "" {
+		return name, nil
+	}
+	config, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(config, "aoc", "input.key"), nil
+}
+
+func parseKey(text string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(text))
+	if err != nil || len(key) != inputKeySize {
+		return nil, fmt.Errorf("An input key must be %d bytes in hex", inputKeySize)
+	}
+	return key, nil
+}
+
+func loadKey() ([]byte, error) {
+	if text := os.Getenv(keyEnv); text != "" {
+		key, err := parseKey(text)
+		if err != nil {
+			return nil, fmt.Errorf("$%s: %s", keyEnv, err)
+		}
+		return key, nil
+	}
+	name, err := keyFile()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("No input key: set $%s, or create %s with \"aoc inputs keygen\" or a copy of the team's key", keyEnv, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+/* The magic, then a random nonce, then the sealed input */
+func encryptInput(key []byte, label string, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := append([]byte(inputMagic), nonce...)
+	return gcm.Seal(sealed, nonce, plaintext, []byte(label)), nil
+}
+
+func decryptInput(key []byte, label string, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte(inputMagic)) || len(data) < len(inputMagic)+gcm.NonceSize() {
+		return nil, fmt.Errorf("The input for %s isn't an encrypted input", label)
+	}
+	data = data[len(inputMagic):]
+	nonce, sealed := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, sealed, []byte(label))
+	if err != nil {
+		return nil, fmt.Errorf("Can't decrypt the input for %s: wrong key, or it was encrypted for another day", label)
+	}
+	return plaintext, nil
+}
+
+func (d *day) decryptInput(key []byte) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(d.dir, sealedInput))
+	if err != nil {
+		return nil, err
+	}
+	return decryptInput(key, d.String(), data)
+}
+
+/*
+ * The file to give the solver: the plain input if there is one, otherwise the
+ * encrypted one decrypted into work. Loading the key is left until a day
+ * actually needs it, so days with plain inputs run without one.
+ */
+func (d *day) inputFile(work string) (string, error) {
+	plain := filepath.Join(d.dir, plainInput)
+	if fileExists(plain) {
+		return plain, nil
+	}
+	sealed := filepath.Join(d.dir, sealedInput)
+	if !fileExists(sealed) {
+		return "", fmt.Errorf("%w for %s in %s", errNoInput, d, d.dir)
+	}
+	key, err := loadKey()
+	if err != nil {
+		return "", fmt.Errorf("The only input is %s, which is encrypted. %s", sealed, err)
+	}
+	decrypted := filepath.Join(work, d.year+"-"+d.number+".input")
+	if err := decryptFile(key, d.String(), sealed, decrypted); err != nil {
+		return "", err
+	}
+	return decrypted, nil
+}
+
+/* Decrypts sealed into decrypted, readable only by the user */
+func decryptFile(key []byte, label string, sealed string, decrypted string) error {
+	data, err := os.ReadFile(sealed)
+	if err != nil {
+		return err
+	}
+	plaintext, err := decryptInput(key, label, data)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(decrypted, plaintext, 0600)
+}
+
+/* Days in [YEAR [DAY]] holding a file called name */
+func selectInputs(flags *flag.FlagSet, args []string, name string) ([]*day, error) {
+	root := flags.String("root", "", "Repository root, defaults to the nearest directory above with year directories")
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(positional) > 2 {
+		flags.Usage()
+		return nil, flag.ErrHelp
+	}
+	if *root, err = resolveRoot(*root); err != nil {
+		return nil, err
+	}
+	year := ""
+	if len(positional) > 0 {
+		year = positional[0]
+	}
+	all, err := listDays(*root, year)
+	if err != nil {
+		return nil, err
+	}
+	var days []*day
+	for _, d := range all {
+		if len(positional) == 2 && d.number != positional[1] {
+			continue
+		}
+		if fileExists(filepath.Join(d.dir, name)) {
+			days = append(days, d)
+		}
+	}
+	if len(days) == 0 {
+		return nil, fmt.Errorf("No %s files found", name)
+	}
+	return days, nil
+}
+
+/* Writes through a temporary file, so an interrupted run can't leave half a file */
+func writeAtomically(name string, data []byte, perm os.FileMode) error {
+	if err := os.WriteFile(name+".tmp", data, perm); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+func keygenCommand(args []string) error {
+	flags := flag.NewFlagSet("inputs keygen", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: aoc inputs keygen")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	name, err := keyFile()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(name); err == nil {
+		return fmt.Errorf("%s already exists, and the inputs encrypted with it would be lost", name)
+	}
+	key := make([]byte, inputKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(name, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote a new key to %s\n", name)
+	return nil
+}
+
+/* Skips days whose input.enc already holds their input, so re-encrypting doesn't churn every file */
+func encryptCommand(args []string) error {
+	flags := flag.NewFlagSet("inputs encrypt", flag.ContinueOnError)
+	remove := flags.Bool("remove", false, "Delete each plain input once it's encrypted")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: aoc inputs encrypt [flags] [YEAR [DAY]]")
+		flags.PrintDefaults()
+	}
+	days, err := selectInputs(flags, args, plainInput)
+	if err != nil {
+		return err
+	}
+	key, err := loadKey()
+	if err != nil {
+		return err
+	}
+	for _, d := range days {
+		plaintext, err := os.ReadFile(filepath.Join(d.dir, plainInput))
+		if err != nil {
+			return err
+		}
+		if existing, err := d.decryptInput(key); err == nil && bytes.Equal(existing, plaintext) {
+			fmt.Printf("%s: unchanged\n", d)
+		} else {
+			sealed, err := encryptInput(key, d.String(), plaintext)
+			if err != nil {
+				return err
+			}
+			if err := writeAtomically(filepath.Join(d.dir, sealedInput), sealed, 0644); err != nil {
+				return err
+			}
+			fmt.Printf("%s: encrypted\n", d)
+		}
+		if *remove {
+			if err := os.Remove(filepath.Join(d.dir, plainInput)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/* Won't overwrite a plain input that differs from the encrypted one unless forced */
+func decryptCommand(args []string) error {
+	flags := flag.NewFlagSet("inputs decrypt", flag.ContinueOnError)
+	force := flags.Bool("force", false, "Overwrite plain inputs that differ from the encrypted ones")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: aoc inputs decrypt [flags] [YEAR [DAY]]")
+		flags.PrintDefaults()
+	}
+	days, err := selectInputs(flags, args, sealedInput)
+	if err != nil {
+		return err
+	}
+	key, err := loadKey()
+	if err != nil {
+		return err
+	}
+	for _, d := range days {
+		plaintext, err := d.decryptInput(key)
+		if err != nil {
+			return err
+		}
+		plain := filepath.Join(d.dir, plainInput)
+		existing, err := os.ReadFile(plain)
+		if err == nil && bytes.Equal(existing, plaintext) {
+			fmt.Printf("%s: unchanged\n", d)
+			continue
+		}
+		if err == nil && !*force {
+			return fmt.Errorf("%s differs from %s for %s, use -force to overwrite it", plain, sealedInput, d)
+		}
+		if err := writeAtomically(plain, plaintext, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("%s: decrypted\n", d)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/* Points the key at a fresh key file, so tests never see the user's own */
+func useTestKey(t *testing.T) []byte {
+	key := bytes.Repeat([]byte{7}, inputKeySize)
+	name := filepath.Join(t.TempDir(), "input.key")
+	os.WriteFile(name, []byte(hex.EncodeToString(key)+"\n"), 0600)
+	t.Setenv(keyEnv, "")
+	t.Setenv(keyFileEnv, name)
+	return key
+}
+
+func TestEncryptInput(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, inputKeySize)
+	sealed, err := encryptInput(key, "2099/1", []byte("1\n2\n"))
+	if err != nil || bytes.Contains(sealed, []byte("1\n2\n")) {
+		t.Log("Error, input not sealed", err)
+		t.Fail()
+	}
+	plaintext, err := decryptInput(key, "2099/1", sealed)
+	if err != nil || string(plaintext) != "1\n2\n" {
+		t.Log("Error, round trip gave", plaintext, err)
+		t.Fail()
+	}
+
+	if _, err := decryptInput(key, "2099/2", sealed); err == nil {
+		t.Log("Expected error decrypting for another day")
+		t.Fail()
+	}
+	if _, err := decryptInput(bytes.Repeat([]byte{2}, inputKeySize), "2099/1", sealed); err == nil {
+		t.Log("Expected error decrypting with another key")
+		t.Fail()
+	}
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := decryptInput(key, "2099/1", tampered); err == nil {
+		t.Log("Expected error decrypting a tampered input")
+		t.Fail()
+	}
+	if _, err := decryptInput(key, "2099/1", []byte("1\n2\n")); err == nil {
+		t.Log("Expected error decrypting a plain input")
+		t.Fail()
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	key := useTestKey(t)
+	if loaded, err := loadKey(); err != nil || !bytes.Equal(loaded, key) {
+		t.Log("Error, key file gave", loaded, err)
+		t.Fail()
+	}
+
+	t.Setenv(keyEnv, strings.Repeat("ab", inputKeySize))
+	if loaded, err := loadKey(); err != nil || loaded[0] != 0xab {
+		t.Log("Error, environment gave", loaded, err)
+		t.Fail()
+	}
+	t.Setenv(keyEnv, "abcd")
+	if _, err := loadKey(); err == nil {
+		t.Log("Expected error for a short key")
+		t.Fail()
+	}
+
+	t.Setenv(keyEnv, "")
+	t.Setenv(keyFileEnv, filepath.Join(t.TempDir(), "missing.key"))
+	if _, err := loadKey(); err == nil || !strings.Contains(err.Error(), "aoc inputs keygen") {
+		t.Log("Expected error explaining how to get a key, got", err)
+		t.Fail()
+	}
+}
+
+func TestRunDayEncrypted(t *testing.T) {
+	key := useTestKey(t)
+	root := createTestRoot(t, map[string]string{"2099/1": testDaySource})
+	d, _ := findDay(root, "2099", "1")
+	sealed, _ := encryptInput(key, d.String(), []byte("1\n2\n"))
+	os.WriteFile(filepath.Join(d.dir, sealedInput), sealed, 0644)
+	cache, _ := openCache(t.TempDir())
+	work := t.TempDir()
+
+	var out bytes.Buffer
+	ok, err := runDay(d, work, cache, false, []int{1, 2}, &out)
+	if err != nil || !ok || !strings.Contains(out.String(), "2099/1 part 2: 6 (") {
+		t.Log("Error, unexpected output", out.String(), err)
+		t.Fail()
+	}
+
+	t.Setenv(keyFileEnv, filepath.Join(t.TempDir(), "missing.key"))
+	if _, err := runDay(d, work, cache, false, []int{1}, &out); err == nil || !strings.Contains(err.Error(), "which is encrypted") {
+		t.Log("Expected error for a missing key, got", err)
+		t.Fail()
+	}
+}
+
+func TestRunInputsEncrypted(t *testing.T) {
+	key := useTestKey(t)
+	root := createTestRoot(t, map[string]string{"2099/1": testDaySource})
+	d, _ := findDay(root, "2099", "1")
+	dir := t.TempDir()
+	sealed, _ := encryptInput(key, d.String(), []byte("1\n2\n"))
+	os.WriteFile(filepath.Join(dir, "alice.enc"), sealed, 0644)
+	os.WriteFile(filepath.Join(dir, "alice.enc.answers"), []byte("3\n6\n"), 0644)
+
+	var out bytes.Buffer
+	ok, err := runInputs(d, t.TempDir(), dir, []int{1, 2}, &out)
+	if err != nil || !ok || !strings.Contains(out.String(), "1 passed") {
+		t.Log("Error, unexpected output", out.String(), err)
+		t.Fail()
+	}
+
+	t.Setenv(keyFileEnv, filepath.Join(t.TempDir(), "missing.key"))
+	if _, err := runInputs(d, t.TempDir(), dir, []int{1}, &out); err == nil || !strings.Contains(err.Error(), "is encrypted") {
+		t.Log("Expected error for a missing key, got", err)
+		t.Fail()
+	}
+}
+
+func TestEncryptDecryptCommands(t *testing.T) {
+	useTestKey(t)
+	root := createTestRoot(t, map[string]string{"2099/1": testDaySource, "2099/2": testDaySource})
+	plain := filepath.Join(root, "2099", "1", plainInput)
+	sealed := filepath.Join(root, "2099", "1", sealedInput)
+	os.WriteFile(plain, []byte("1\n2\n"), 0644)
+
+	if err := encryptCommand([]string{"-root", root}); err != nil {
+		t.Log("Failed to encrypt:", err)
+		t.FailNow()
+	}
+	first, _ := os.ReadFile(sealed)
+	if err := encryptCommand([]string{"-root", root, "-remove", "2099"}); err != nil {
+		t.Log("Failed to encrypt again:", err)
+		t.FailNow()
+	}
+	second, _ := os.ReadFile(sealed)
+	if !bytes.Equal(first, second) || fileExists(plain) {
+		t.Log("Error, expected the same input.enc and no plain input")
+		t.Fail()
+	}
+	if fileExists(filepath.Join(root, "2099", "2", sealedInput)) {
+		t.Log("Error, encrypted a day without an input")
+		t.Fail()
+	}
+
+	if err := decryptCommand([]string{"-root", root, "2099", "1"}); err != nil {
+		t.Log("Failed to decrypt:", err)
+		t.FailNow()
+	}
+	if data, _ := os.ReadFile(plain); string(data) != "1\n2\n" {
+		t.Log("Error, decrypted to", string(data))
+		t.Fail()
+	}
+
+	os.WriteFile(plain, []byte("3\n"), 0644)
+	if err := decryptCommand([]string{"-root", root}); err == nil {
+		t.Log("Expected error overwriting an edited input")
+		t.Fail()
+	}
+	if err := decryptCommand([]string{"-root", root, "-force"}); err != nil {
+		t.Log("Failed to force decrypt:", err)
+		t.Fail()
+	}
+	if data, _ := os.ReadFile(plain); string(data) != "1\n2\n" {
+		t.Log("Error, forced decrypt gave", string(data))
+		t.Fail()
+	}
+}
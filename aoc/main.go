@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+/*
+ * Runs the solvers in the year/day directories. Each day is its own program,
+ * so the runner builds and runs them rather than importing them, and talks to
+ * them through the flags they share: -f for the input, -2 for part 2, and
+ * -generate or -reference where a day has them. Inputs may be kept encrypted,
+ * in which case the runner decrypts them for the solver.
+ */
+
+const usage = `Usage: aoc COMMAND [flags] [YEAR [DAY]]
+
+Commands:
+  run         Print the answers for every day, a year's days or one day
+  crosscheck  Compare a day's solver with its -reference solver on the real
+              input and on generated inputs
+  inputs      Encrypt or decrypt the puzzle inputs
+
+Run "aoc COMMAND -h" for the command's flags.
+`
+
+/* Returned once a command has reported its own failures, so main only sets the exit status */
+var errFailed = errors.New("Failed")
+
+/* Errors should be printed to STDERR not STDOUT */
+func die(err error) {
+	fmt.Fprintf(os.Stderr, "%s\n", err)
+	os.Exit(1)
+}
+
+/* Lets flags come after YEAR and DAY as well as before them */
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func main() {
+	commands := map[string]func(args []string) error{
+		"run":        runCommand,
+		"crosscheck": crosscheckCommand,
+		"inputs":     inputsCommand,
+	}
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	switch os.Args[1] {
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+		return
+	}
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	err := command(os.Args[2:])
+	if errors.Is(err, errFailed) {
+		os.Exit(1)
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		die(err)
+	}
+}
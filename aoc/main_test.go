@@ -0,0 +1,23 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	seeds := flags.Int("seeds", 1, "")
+	size := flags.String("size", "", "")
+	positional, err := parseArgs(flags, []string{"-seeds", "5", "2020", "4", "--size", "3"})
+	if err != nil || !reflect.DeepEqual(positional, []string{"2020", "4"}) || *seeds != 5 || *size != "3" {
+		t.Log("Error, unexpected arguments", positional, *seeds, *size, err)
+		t.Fail()
+	}
+
+	if _, err := parseArgs(flags, []string{"2020", "-unknown"}); err == nil {
+		t.Log("Expected error for an unknown flag")
+		t.Fail()
+	}
+}
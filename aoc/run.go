@@ -0,0 +1,286 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+/*
+ * Runs every day's solver on its input, or just a year's or a day's. Answers
+ * are always saved to the cache; -cached reuses them when neither the solver
+ * nor the input has changed since. With -inputs a single day is run on every
+ * file in a directory instead, such as inputs collected from other people.
+ */
+func runCommand(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	root := flags.String("root", "", "Repository root, defaults to the nearest directory above with year directories")
+	cached := flags.Bool("cached", false, "Reuse answers from earlier runs where neither the solver nor the input has changed")
+	cacheDir := flags.String("cache-dir", "", "Where answers are kept, defaults to aoc/answers in the user cache directory")
+	part := flags.Int("part", 0, "Only run part 1 or 2, both by default")
+	inputs := flags.String("inputs", "", "Run one day on every file in this directory, checking each against FILE.answers where there is one")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: aoc run [flags] [YEAR [DAY]]")
+		flags.PrintDefaults()
+	}
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 2 || *part < 0 || *part > 2 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	if *inputs != "" && (len(positional) != 2 || *cached) {
+		return fmt.Errorf("-inputs needs a YEAR and DAY, and doesn't use the cache")
+	}
+	parts := []int{1, 2}
+	if *part != 0 {
+		parts = []int{*part}
+	}
+
+	if *root, err = resolveRoot(*root); err != nil {
+		return err
+	}
+	var days []*day
+	switch len(positional) {
+	case 2:
+		d, err := findDay(*root, positional[0], positional[1])
+		if err != nil {
+			return err
+		}
+		days = []*day{d}
+	case 1:
+		days, err = findDays(*root, positional[0])
+	default:
+		days, err = findDays(*root, "")
+	}
+	if err != nil {
+		return err
+	}
+
+	work, err := os.MkdirTemp("", "aoc-run")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+	if *inputs != "" {
+		days[0].stderr = os.Stderr
+		ok, err := runInputs(days[0], work, *inputs, parts, os.Stdout)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errFailed
+		}
+		return nil
+	}
+
+	cache, err := openCache(*cacheDir)
+	if err != nil {
+		return err
+	}
+
+	failed := false
+	for _, d := range days {
+		d.stderr = os.Stderr
+		ok, err := runDay(d, work, cache, *cached, parts, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", d, err)
+			ok = false
+		}
+		failed = failed || !ok
+	}
+	if failed {
+		return errFailed
+	}
+	return nil
+}
+
+/* Prints each part's answer to w, returning whether every part succeeded */
+func runDay(d *day, work string, cache *answerCache, cached bool, parts []int, w io.Writer) (bool, error) {
+	input, err := d.inputFile(work)
+	if err != nil {
+		return false, err
+	}
+	inputHash, err := hashFile(input)
+	if err != nil {
+		return false, err
+	}
+	if err := d.build(work); err != nil {
+		return false, err
+	}
+
+	ok := true
+	for _, part := range parts {
+		key := cacheKey{d.year, d.number, part, inputHash, d.buildID}
+		if cached {
+			if entry, hit := cache.load(key); hit {
+				fmt.Fprintf(w, "%s part %d: %s (cached)\n", d, part, entry.Answer)
+				continue
+			}
+		}
+
+		start := time.Now()
+		result, err := d.solve(input, part)
+		took := time.Since(start)
+		if err != nil {
+			return false, err
+		}
+		if !result.ok {
+			fmt.Fprintf(w, "%s part %d: %s\n", d, part, result)
+			ok = false
+			continue
+		}
+		fmt.Fprintf(w, "%s part %d: %s (%s)\n", d, part, result.answer, took.Round(time.Millisecond))
+		if err := cache.store(key, result.answer, took); err != nil {
+			return false, err
+		}
+	}
+	return ok, nil
+}
+
+/* Inputs in dir with the answers expected for them, if known */
+type alternativeInput struct {
+	name     string
+	file     string
+	expected []string // by part, empty where unknown
+}
+
+/*
+ * Every file in dir except the .answers files, whose lines are the answers to
+ * parts 1 and 2. A .enc file is an input encrypted for the day, like input.enc,
+ * and its answers are in the .enc.answers file.
+ */
+func findInputs(dir string) ([]alternativeInput, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var inputs []alternativeInput
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".answers") {
+			continue
+		}
+		in := alternativeInput{name: name, file: filepath.Join(dir, name), expected: make([]string, 2)}
+		data, err := os.ReadFile(in.file + ".answers")
+		if err == nil {
+			for i, answer := range strings.SplitN(strings.TrimRight(string(data), "\n"), "\n", 3) {
+				if i < 2 {
+					in.expected[i] = strings.TrimSpace(answer)
+				}
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		inputs = append(inputs, in)
+	}
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("No inputs in %s", dir)
+	}
+	return inputs, nil
+}
+
+/*
+ * Prints a row per input with each part's answer, whether it was right and
+ * how long it took, then a summary. An input passes if every part with a
+ * known answer got it; a solver failing on an input is wrong whatever the
+ * answers. Returns whether nothing failed.
+ */
+func runInputs(d *day, work string, dir string, parts []int, w io.Writer) (bool, error) {
+	/* The solver runs in its own directory, so a relative path would point somewhere else */
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false, err
+	}
+	inputs, err := findInputs(dir)
+	if err != nil {
+		return false, err
+	}
+	if err := decryptInputs(d, work, inputs); err != nil {
+		return false, err
+	}
+	if err := d.build(work); err != nil {
+		return false, err
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(table, "input")
+	for _, part := range parts {
+		fmt.Fprintf(table, "\tpart %d", part)
+	}
+	fmt.Fprintln(table, "\ttotal")
+
+	passed, failed, unchecked := 0, 0, 0
+	for _, in := range inputs {
+		fmt.Fprint(table, in.name)
+		wrong, checked := false, false
+		var total time.Duration
+		for _, part := range parts {
+			start := time.Now()
+			result, err := d.solve(in.file, part)
+			took := time.Since(start)
+			if err != nil {
+				return false, err
+			}
+			total += took
+
+			expected := in.expected[part-1]
+			status := ""
+			switch {
+			case !result.ok:
+				wrong = true
+			case expected == "":
+			case result.answer == expected:
+				status, checked = " ok", true
+			default:
+				status, wrong = " WRONG, expected "+expected, true
+			}
+			fmt.Fprintf(table, "\t%s%s (%s)", result, status, took.Round(time.Millisecond))
+		}
+		fmt.Fprintf(table, "\t%s\n", total.Round(time.Millisecond))
+
+		switch {
+		case wrong:
+			failed++
+		case checked:
+			passed++
+		default:
+			unchecked++
+		}
+	}
+	if err := table.Flush(); err != nil {
+		return false, err
+	}
+	fmt.Fprintf(w, "%s: %d inputs, %d passed, %d failed, %d without answers\n", d, len(inputs), passed, failed, unchecked)
+	return failed == 0, nil
+}
+
+/* Points each encrypted input at a decrypted copy in work, loading the key only if there is one */
+func decryptInputs(d *day, work string, inputs []alternativeInput) error {
+	var key []byte
+	for i := range inputs {
+		if filepath.Ext(inputs[i].name) != ".enc" {
+			continue
+		}
+		if key == nil {
+			var err error
+			if key, err = loadKey(); err != nil {
+				return fmt.Errorf("%s is encrypted. %s", inputs[i].file, err)
+			}
+		}
+		decrypted := filepath.Join(work, fmt.Sprintf("%s-%s-%d.input", d.year, d.number, i))
+		if err := decryptFile(key, d.String(), inputs[i].file, decrypted); err != nil {
+			return fmt.Errorf("%s: %s", inputs[i].name, err)
+		}
+		inputs[i].file = decrypted
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindDays(t *testing.T) {
+	root := createTestRoot(t, map[string]string{
+		"2099/10": testDaySource,
+		"2099/9":  testDaySource,
+		"2098/1":  testDaySource,
+	})
+	os.MkdirAll(filepath.Join(root, "2099", "11"), 0755)
+	os.MkdirAll(filepath.Join(root, "templates"), 0755)
+
+	var names []string
+	days, err := findDays(root, "")
+	for _, d := range days {
+		names = append(names, d.String())
+	}
+	if err != nil || strings.Join(names, " ") != "2098/1 2099/9 2099/10" {
+		t.Log("Error, unexpected days", names, err)
+		t.Fail()
+	}
+
+	days, err = findDays(root, "2098")
+	if err != nil || len(days) != 1 {
+		t.Log("Error, expected just 2098/1, got", days, err)
+		t.Fail()
+	}
+	if _, err := findDays(root, "2097"); err == nil {
+		t.Log("Expected error for a missing year")
+		t.Fail()
+	}
+}
+
+func TestRunDayCached(t *testing.T) {
+	root := createTestRoot(t, map[string]string{"2099/1": testDaySource})
+	input := filepath.Join(root, "2099", "1", "input")
+	os.WriteFile(input, []byte("1\n2\n"), 0644)
+	d, _ := findDay(root, "2099", "1")
+	cache, _ := openCache(t.TempDir())
+	work := t.TempDir()
+
+	run := func(cached bool) string {
+		var out bytes.Buffer
+		ok, err := runDay(d, work, cache, cached, []int{1, 2}, &out)
+		if err != nil || !ok {
+			t.Log("Unexpected failure:", err, out.String())
+			t.Fail()
+		}
+		return out.String()
+	}
+
+	first := run(true)
+	if !strings.Contains(first, "2099/1 part 1: 3 (") || !strings.Contains(first, "2099/1 part 2: 6 (") || strings.Contains(first, "cached") {
+		t.Log("Error, unexpected first run", first)
+		t.Fail()
+	}
+	if again := run(true); again != "2099/1 part 1: 3 (cached)\n2099/1 part 2: 6 (cached)\n" {
+		t.Log("Error, expected cached answers, got", again)
+		t.Fail()
+	}
+	if uncached := run(false); strings.Contains(uncached, "cached") {
+		t.Log("Error, used the cache without -cached", uncached)
+		t.Fail()
+	}
+
+	os.WriteFile(input, []byte("1\n2\n3\n"), 0644)
+	if changed := run(true); !strings.Contains(changed, "part 1: 6 (") || strings.Contains(changed, "cached") {
+		t.Log("Error, expected a changed input to be rerun, got", changed)
+		t.Fail()
+	}
+
+	source := filepath.Join(root, "2099", "1", "main.go")
+	os.WriteFile(source, []byte(strings.Replace(testDaySource, "number *= 2", "number *= 3", 1)), 0644)
+	if rebuilt := run(true); !strings.Contains(rebuilt, "part 2: 18 (") || strings.Contains(rebuilt, "cached") {
+		t.Log("Error, expected a changed solver to be rerun, got", rebuilt)
+		t.Fail()
+	}
+}
+
+func TestRunDayFailure(t *testing.T) {
+	root := createTestRoot(t, map[string]string{"2099/1": testDaySource})
+	input := filepath.Join(root, "2099", "1", "input")
+	os.WriteFile(input, []byte("x\n"), 0644)
+	d, _ := findDay(root, "2099", "1")
+	cache, _ := openCache(t.TempDir())
+
+	var out bytes.Buffer
+	ok, err := runDay(d, t.TempDir(), cache, true, []int{1}, &out)
+	if err != nil || ok || !strings.Contains(out.String(), "failed") {
+		t.Log("Expected part 1 to fail, got", ok, err, out.String())
+		t.Fail()
+	}
+	if entries, _ := filepath.Glob(filepath.Join(cache.dir, "2099", "1", "*")); len(entries) != 0 {
+		t.Log("Error, cached a failure", entries)
+		t.Fail()
+	}
+
+	os.Remove(input)
+	if _, err := runDay(d, t.TempDir(), cache, true, []int{1}, &out); err == nil {
+		t.Log("Expected error for a missing input")
+		t.Fail()
+	}
+}
+
+func TestRunInputs(t *testing.T) {
+	root := createTestRoot(t, map[string]string{"2099/1": testDaySource})
+	d, _ := findDay(root, "2099", "1")
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "right"), []byte("1\n2\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "right.answers"), []byte("3\n6\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "unknown"), []byte("4\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "wrong"), []byte("13\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "wrong.answers"), []byte("13\n"), 0644)
+	os.WriteFile(filepath.Join(dir, ".hidden"), []byte("x\n"), 0644)
+
+	inputs, err := findInputs(dir)
+	if err != nil || len(inputs) != 3 || inputs[0].expected[1] != "6" || inputs[2].expected[1] != "" {
+		t.Log("Error, unexpected inputs", inputs, err)
+		t.Fail()
+	}
+
+	var out bytes.Buffer
+	ok, err := runInputs(d, t.TempDir(), dir, []int{1, 2}, &out)
+	if err != nil || ok {
+		t.Log("Expected the wrong input to fail, got", ok, err)
+		t.Fail()
+	}
+	for _, want := range []string{"3 ok (", "6 ok (", "0 WRONG, expected 13 (", "2099/1: 3 inputs, 1 passed, 1 failed, 1 without answers"} {
+		if !strings.Contains(out.String(), want) {
+			t.Log("Error, expected", want, "in", out.String())
+			t.Fail()
+		}
+	}
+
+	os.Remove(filepath.Join(dir, "wrong"))
+	os.WriteFile(filepath.Join(dir, "broken"), []byte("x\n"), 0644)
+	out.Reset()
+	if ok, err := runInputs(d, t.TempDir(), dir, []int{1}, &out); err != nil || ok || !strings.Contains(out.String(), "failed") {
+		t.Log("Expected a failing solver to fail the input, got", ok, err, out.String())
+		t.Fail()
+	}
+}
+
+func TestRunCommandRelativeRoot(t *testing.T) {
+	root := createTestRoot(t, map[string]string{"2099/1": testDaySource})
+	os.WriteFile(filepath.Join(root, "2099", "1", "input"), []byte("1\n2\n"), 0644)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(filepath.Join(root, "2099"))
+	if err := runCommand([]string{"-root", "..", "-cache-dir", t.TempDir(), "2099", "1"}); err != nil {
+		t.Log("Failed to run with a relative -root:", err)
+		t.Fail()
+	}
+}
+
+func TestRunInputsRelative(t *testing.T) {
+	root := createTestRoot(t, map[string]string{"2099/1": testDaySource})
+	d, _ := findDay(root, "2099", "1")
+	parent := t.TempDir()
+	os.Mkdir(filepath.Join(parent, "altin"), 0755)
+	os.WriteFile(filepath.Join(parent, "altin", "mine"), []byte("1\n2\n"), 0644)
+	os.WriteFile(filepath.Join(parent, "altin", "mine.answers"), []byte("3\n6\n"), 0644)
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(parent)
+	var out bytes.Buffer
+	ok, err := runInputs(d, t.TempDir(), "altin", []int{1, 2}, &out)
+	if err != nil || !ok || !strings.Contains(out.String(), "1 passed") {
+		t.Log("Error, unexpected output for a relative directory", out.String(), err)
+		t.Fail()
+	}
+}